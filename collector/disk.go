@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var SnapshotBaseName = "%s-%s"
+
+// DiskCollector finds the disks created by gce-docker without users, without
+// a container mounting them and not used during the retention period.
+type DiskCollector struct {
+	Retention time.Duration
+	DryRun    bool
+	Snapshot  bool
+
+	p providers.DiskProvider
+	d *docker.Client
+}
+
+func NewDiskCollector(p providers.DiskProvider, d *docker.Client) *DiskCollector {
+	return &DiskCollector{p: p, d: d}
+}
+
+// Collect returns the orphaned disks, the disks are snapshotted, if
+// required, and deleted unless DryRun is set.
+func (c *DiskCollector) Collect() ([]*compute.Disk, error) {
+	disks, err := c.p.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing disks: %s", err)
+	}
+
+	volumes, err := c.volumes()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+
+	now := time.Now()
+	var orphans []*compute.Disk
+	for _, d := range disks {
+		if !isOrphanDisk(d, volumes, c.Retention, now) {
+			continue
+		}
+
+		orphans = append(orphans, d)
+		if c.DryRun {
+			log15.Info("orphaned disk found", "disk", d.Name, "last-used", lastDiskUse(d))
+			continue
+		}
+
+		if err := c.collect(d, now); err != nil {
+			log15.Error("error collecting disk", "disk", d.Name, "error", err)
+		}
+	}
+
+	return orphans, nil
+}
+
+func (c *DiskCollector) collect(d *compute.Disk, now time.Time) error {
+	start := time.Now()
+	config := &providers.DiskConfig{Name: d.Name, Volume: d.Labels[providers.LabelVolume]}
+	if c.Snapshot {
+		snapshot := fmt.Sprintf(SnapshotBaseName, d.Name, now.Format("20060102150405"))
+		if err := c.p.Snapshot(config, snapshot); err != nil {
+			return fmt.Errorf("error creating snapshot: %s", err)
+		}
+
+		log15.Info("orphaned disk snapshotted", "disk", d.Name, "snapshot", snapshot)
+	}
+
+	if err := c.p.Delete(config); err != nil {
+		return fmt.Errorf("error deleting disk: %s", err)
+	}
+
+	log15.Info("orphaned disk deleted", "disk", d.Name, "elapsed", time.Since(start))
+	return nil
+}
+
+// volumes returns the gce volumes mounted by any container, running or not.
+// The docker volumes can't be used, since the ones of the gce driver are
+// listed from the disks themselves.
+func (c *DiskCollector) volumes() (map[string]bool, error) {
+	containers, err := c.d.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return mountedVolumes(containers), nil
+}
+
+func mountedVolumes(containers []docker.APIContainers) map[string]bool {
+	names := make(map[string]bool, 0)
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if m.Driver == "gce" && m.Name != "" {
+				names[m.Name] = true
+			}
+		}
+	}
+
+	return names
+}
+
+// Run collects the orphaned disks every interval, forever.
+func (c *DiskCollector) Run(interval time.Duration) {
+	for {
+		if _, err := c.Collect(); err != nil {
+			log15.Error("error collecting disks", "error", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func isOrphanDisk(d *compute.Disk, volumes map[string]bool, retention time.Duration, now time.Time) bool {
+	volume, ok := d.Labels[providers.LabelVolume]
+	if !ok {
+		return false
+	}
+
+	if len(d.Users) != 0 || volumes[volume] {
+		return false
+	}
+
+	return now.Sub(lastDiskUse(d)) > retention
+}
+
+// lastDiskUse returns the most recent of the creation, attach or detach time.
+func lastDiskUse(d *compute.Disk) time.Time {
+	var last time.Time
+	for _, ts := range []string{
+		d.CreationTimestamp, d.LastAttachTimestamp, d.LastDetachTimestamp,
+	} {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err == nil && t.After(last) {
+			last = t
+		}
+	}
+
+	return last
+}
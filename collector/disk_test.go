@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DiskSuite struct{}
+
+var _ = Suite(&DiskSuite{})
+
+func (s *DiskSuite) TestIsOrphanDisk(c *C) {
+	now, _ := time.Parse(time.RFC3339, "2016-06-10T10:00:00Z")
+	volumes := map[string]bool{"bar": true}
+	retention := 24 * time.Hour
+
+	d := &compute.Disk{
+		Name:                "foo-0",
+		Labels:              map[string]string{"gce-docker-volume": "foo"},
+		CreationTimestamp:   "2016-06-01T10:00:00.000-07:00",
+		LastDetachTimestamp: "2016-06-08T10:00:00.000-07:00",
+	}
+	c.Assert(isOrphanDisk(d, volumes, retention, now), Equals, true)
+
+	d.LastAttachTimestamp = "2016-06-10T01:00:00.000-07:00"
+	c.Assert(isOrphanDisk(d, volumes, retention, now), Equals, false)
+	d.LastAttachTimestamp = ""
+
+	d.Users = []string{"instance"}
+	c.Assert(isOrphanDisk(d, volumes, retention, now), Equals, false)
+	d.Users = nil
+
+	d.Labels["gce-docker-volume"] = "bar"
+	c.Assert(isOrphanDisk(d, volumes, retention, now), Equals, false)
+
+	d.Labels = nil
+	c.Assert(isOrphanDisk(d, volumes, retention, now), Equals, false)
+}
+
+func (s *DiskSuite) TestLastDiskUse(c *C) {
+	d := &compute.Disk{
+		CreationTimestamp:   "2016-06-01T10:00:00.000-07:00",
+		LastAttachTimestamp: "2016-06-09T10:00:00.000-07:00",
+		LastDetachTimestamp: "2016-06-08T10:00:00.000-07:00",
+	}
+
+	c.Assert(lastDiskUse(d).UTC().Format(time.RFC3339), Equals, "2016-06-09T17:00:00Z")
+}
+
+func (s *DiskSuite) TestMountedVolumes(c *C) {
+	volumes := mountedVolumes([]docker.APIContainers{
+		{Mounts: []docker.APIMount{
+			{Name: "foo", Driver: "gce"},
+			{Name: "bar", Driver: "local"},
+		}},
+		{Mounts: []docker.APIMount{
+			{Source: "/tmp", Destination: "/tmp"},
+		}},
+	})
+
+	c.Assert(volumes, DeepEquals, map[string]bool{"foo": true})
+}
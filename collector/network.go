@@ -0,0 +1,426 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// NetworkCollector finds the load balancer resources created by gce-docker
+// that don't belong to any running container, either of this instance or of
+// any other instance of the project.
+type NetworkCollector struct {
+	MinAge time.Duration
+	DryRun bool
+
+	p *providers.Network
+}
+
+func NewNetworkCollector(p *providers.Network) *NetworkCollector {
+	return &NetworkCollector{p: p}
+}
+
+// Collect tears down the load balancers of this instance not present at the
+// given active configs, and after that, the resources of the project not tied
+// to any existing instance and older than MinAge. Unless DryRun is set the
+// orphaned resources are deleted.
+func (c *NetworkCollector) Collect(active []*providers.NetworkConfig) ([]providers.Resource, error) {
+	if err := c.collectInstance(active); err != nil {
+		return nil, err
+	}
+
+	r, err := c.p.Resources()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := findNetworkOrphans(r, c.MinAge, time.Now())
+	for _, o := range orphans {
+		if c.DryRun {
+			log15.Info("orphaned resource found", "kind", o.Kind, "name", o.Name, "created", o.CreationTimestamp)
+			continue
+		}
+
+		if err := c.p.DeleteResource(o); err != nil {
+			log15.Error("error deleting resource", "kind", o.Kind, "name", o.Name, "error", err)
+			continue
+		}
+
+		log15.Info("orphaned resource deleted", "kind", o.Kind, "name", o.Name)
+	}
+
+	return orphans, nil
+}
+
+func (c *NetworkCollector) collectInstance(active []*providers.NetworkConfig) error {
+	orphans, err := c.p.Orphans(active)
+	if err != nil {
+		return fmt.Errorf("error listing instance load balancers: %s", err)
+	}
+
+	for _, config := range orphans {
+		if c.DryRun {
+			log15.Info("orphaned load balancer found", "container", config.Container, "group", config.GroupName)
+			continue
+		}
+
+		if err := c.p.Delete(config); err != nil {
+			log15.Error("error deleting load balancer", "container", config.Container, "error", err)
+			continue
+		}
+
+		log15.Info("orphaned load balancer deleted", "container", config.Container, "group", config.GroupName)
+	}
+
+	return nil
+}
+
+// findNetworkOrphans returns the orphaned resources older than minAge, in the
+// order they should be deleted. A target pool is orphaned when none of its
+// instances exist and no instance has its tag, a backend service when all its
+// instance groups are missing, or empty and untagged, a forwarding rule when
+// its target pool or backend service is missing or orphaned, a firewall when
+// no instance has its target tags, a health check when no remaining target
+// pool or backend service, of any region, references it and an instance group
+// when is empty, untagged and no remaining backend service references it. The
+// resources of the https load balancers are collected by findGlobalOrphans,
+// and the released addresses once no forwarding rule uses them.
+func findNetworkOrphans(r *providers.NetworkResources, minAge time.Duration, now time.Time) []providers.Resource {
+	old := func(ts string) bool {
+		t, err := time.Parse(time.RFC3339, ts)
+		return err == nil && now.Sub(t) > minAge
+	}
+
+	// the instances of a disabled load balancer, as a paused container, are
+	// removed from the pool or the group but keep the tag
+	tags := make(map[string]bool, 0)
+	for _, instanceTags := range r.Instances {
+		for _, t := range instanceTags {
+			tags[t] = true
+		}
+	}
+
+	var pools []providers.Resource
+	live := make(map[string]bool, 0)
+	checks := make(map[string]bool, 0)
+	for _, p := range r.TargetPools {
+		if isOrphanTargetPool(p.Instances, r.Instances) && !tags[p.Name] && old(p.CreationTimestamp) {
+			pools = append(pools, providers.Resource{
+				Kind: providers.KindTargetPool, Name: p.Name, CreationTimestamp: p.CreationTimestamp,
+			})
+
+			continue
+		}
+
+		live[p.Name] = true
+		for _, hc := range p.HealthChecks {
+			checks[providers.ResourceName(hc)] = true
+		}
+	}
+
+	for _, p := range r.RemoteTargetPools {
+		for _, hc := range p.HealthChecks {
+			checks[providers.ResourceName(hc)] = true
+		}
+	}
+
+	groups := make(map[string]bool, 0)
+	for _, g := range r.InstanceGroups {
+		if g.Size != 0 || tags[g.Name] {
+			groups[g.SelfLink] = true
+		}
+	}
+
+	var services []providers.Resource
+	liveServices := make(map[string]bool, 0)
+	backendChecks := make(map[string]bool, 0)
+	usedGroups := make(map[string]bool, 0)
+	for _, b := range r.BackendServices {
+		if !hasLiveBackend(b.Backends, groups) && old(b.CreationTimestamp) {
+			services = append(services, providers.Resource{
+				Kind: providers.KindBackendService, Name: b.Name, CreationTimestamp: b.CreationTimestamp,
+			})
+
+			continue
+		}
+
+		liveServices[b.Name] = true
+		addBackendRefs(b, backendChecks, usedGroups)
+	}
+
+	for _, b := range r.RemoteBackendServices {
+		addBackendRefs(b, backendChecks, usedGroups)
+	}
+
+	global := findGlobalOrphans(r, groups, old, backendChecks, usedGroups)
+
+	var orphans []providers.Resource
+	for _, f := range r.ForwardingRules {
+		if !old(f.CreationTimestamp) {
+			continue
+		}
+
+		if f.BackendService != "" && liveServices[providers.ResourceName(f.BackendService)] {
+			continue
+		}
+
+		if f.BackendService == "" && live[providers.ResourceName(f.Target)] {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindForwardingRule, Name: f.Name, CreationTimestamp: f.CreationTimestamp,
+		})
+	}
+
+	orphans = append(orphans, global.rules...)
+	orphans = append(orphans, global.proxies...)
+	orphans = append(orphans, global.urlMaps...)
+	orphans = append(orphans, pools...)
+	orphans = append(orphans, services...)
+	orphans = append(orphans, global.services...)
+	orphans = append(orphans, global.certificates...)
+	orphans = append(orphans, global.policies...)
+
+	for _, f := range r.Firewalls {
+		if isTagged(f.TargetTags, tags) || !old(f.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindFirewall, Name: f.Name, CreationTimestamp: f.CreationTimestamp,
+			Project: providers.ResourceProject(f.SelfLink),
+		})
+	}
+
+	for _, hc := range r.HttpHealthChecks {
+		if checks[hc.Name] || !old(hc.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindHttpHealthCheck, Name: hc.Name, CreationTimestamp: hc.CreationTimestamp,
+		})
+	}
+
+	for _, hc := range r.HealthChecks {
+		if backendChecks[hc.Name] || !old(hc.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindHealthCheck, Name: hc.Name, CreationTimestamp: hc.CreationTimestamp,
+		})
+	}
+
+	for _, hc := range r.RegionHealthChecks {
+		if backendChecks[hc.Name] || !old(hc.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindRegionHealthCheck, Name: hc.Name, CreationTimestamp: hc.CreationTimestamp,
+		})
+	}
+
+	for _, g := range r.InstanceGroups {
+		if groups[g.SelfLink] || usedGroups[g.SelfLink] || !old(g.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindInstanceGroup, Name: g.Name, CreationTimestamp: g.CreationTimestamp,
+			Zone: providers.ResourceName(g.Zone),
+		})
+	}
+
+	for _, a := range r.Addresses {
+		if a.Status != "RESERVED" || !old(a.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindAddress, Name: a.Name, CreationTimestamp: a.CreationTimestamp,
+		})
+	}
+
+	for _, a := range r.GlobalAddresses {
+		if a.Status != "RESERVED" || !old(a.CreationTimestamp) {
+			continue
+		}
+
+		orphans = append(orphans, providers.Resource{
+			Kind: providers.KindGlobalAddress, Name: a.Name, CreationTimestamp: a.CreationTimestamp,
+		})
+	}
+
+	return orphans
+}
+
+// globalOrphans are the orphaned resources of the https load balancers.
+type globalOrphans struct {
+	rules        []providers.Resource
+	proxies      []providers.Resource
+	urlMaps      []providers.Resource
+	services     []providers.Resource
+	certificates []providers.Resource
+	policies     []providers.Resource
+}
+
+// findGlobalOrphans returns the orphaned resources of the https load balancers,
+// older than minAge. A global backend service is orphaned as the regional ones,
+// a URL map when none of its backend services remain, a proxy when its URL map
+// is missing or orphaned, a forwarding rule when its proxy is, and a
+// certificate or a security policy when no remaining proxy or backend service
+// references it. The health checks and instance groups of the remaining
+// services are added to checks and usedGroups.
+func findGlobalOrphans(r *providers.NetworkResources, groups map[string]bool, old func(string) bool,
+	checks, usedGroups map[string]bool) globalOrphans {
+
+	var o globalOrphans
+	services := make(map[string]bool, 0)
+	policies := make(map[string]bool, 0)
+	for _, b := range r.GlobalBackendServices {
+		if !hasLiveBackend(b.Backends, groups) && old(b.CreationTimestamp) {
+			o.services = append(o.services, providers.Resource{
+				Kind: providers.KindGlobalBackendService, Name: b.Name, CreationTimestamp: b.CreationTimestamp,
+			})
+
+			continue
+		}
+
+		services[b.Name] = true
+		addBackendRefs(b, checks, usedGroups)
+		if b.SecurityPolicy != "" {
+			policies[providers.ResourceName(b.SecurityPolicy)] = true
+		}
+	}
+
+	urlMaps := make(map[string]bool, 0)
+	for _, m := range r.UrlMaps {
+		if !hasLiveService(urlMapServices(m), services) && old(m.CreationTimestamp) {
+			o.urlMaps = append(o.urlMaps, providers.Resource{
+				Kind: providers.KindUrlMap, Name: m.Name, CreationTimestamp: m.CreationTimestamp,
+			})
+
+			continue
+		}
+
+		urlMaps[m.Name] = true
+	}
+
+	proxies := make(map[string]bool, 0)
+	certificates := make(map[string]bool, 0)
+	for _, p := range r.TargetHttpsProxies {
+		if !urlMaps[providers.ResourceName(p.UrlMap)] && old(p.CreationTimestamp) {
+			o.proxies = append(o.proxies, providers.Resource{
+				Kind: providers.KindTargetHttpsProxy, Name: p.Name, CreationTimestamp: p.CreationTimestamp,
+			})
+
+			continue
+		}
+
+		proxies[p.Name] = true
+		for _, c := range p.SslCertificates {
+			certificates[providers.ResourceName(c)] = true
+		}
+	}
+
+	for _, f := range r.GlobalForwardingRules {
+		if proxies[providers.ResourceName(f.Target)] || !old(f.CreationTimestamp) {
+			continue
+		}
+
+		o.rules = append(o.rules, providers.Resource{
+			Kind: providers.KindGlobalForwardingRule, Name: f.Name, CreationTimestamp: f.CreationTimestamp,
+		})
+	}
+
+	for _, c := range r.SslCertificates {
+		if certificates[c.Name] || !old(c.CreationTimestamp) {
+			continue
+		}
+
+		o.certificates = append(o.certificates, providers.Resource{
+			Kind: providers.KindSslCertificate, Name: c.Name, CreationTimestamp: c.CreationTimestamp,
+		})
+	}
+
+	for _, p := range r.SecurityPolicies {
+		if policies[p.Name] || !old(p.CreationTimestamp) {
+			continue
+		}
+
+		o.policies = append(o.policies, providers.Resource{
+			Kind: providers.KindSecurityPolicy, Name: p.Name, CreationTimestamp: p.CreationTimestamp,
+		})
+	}
+
+	return o
+}
+
+// urlMapServices returns the backend services referenced by the URL map.
+func urlMapServices(m *compute.UrlMap) []string {
+	services := []string{m.DefaultService}
+	for _, pm := range m.PathMatchers {
+		services = append(services, pm.DefaultService)
+		for _, rule := range pm.PathRules {
+			services = append(services, rule.Service)
+		}
+	}
+
+	return services
+}
+
+func hasLiveService(services []string, live map[string]bool) bool {
+	for _, s := range services {
+		if s != "" && live[providers.ResourceName(s)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasLiveBackend(backends []*compute.Backend, groups map[string]bool) bool {
+	for _, b := range backends {
+		if groups[b.Group] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func addBackendRefs(b *compute.BackendService, checks, groups map[string]bool) {
+	for _, hc := range b.HealthChecks {
+		checks[providers.ResourceName(hc)] = true
+	}
+
+	for _, backend := range b.Backends {
+		groups[backend.Group] = true
+	}
+}
+
+func isOrphanTargetPool(members []string, instances map[string][]string) bool {
+	for _, m := range members {
+		if _, ok := instances[m]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTagged(targets []string, tags map[string]bool) bool {
+	for _, t := range targets {
+		if tags[t] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,196 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+type NetworkSuite struct{}
+
+var _ = Suite(&NetworkSuite{})
+
+func (s *NetworkSuite) TestFindNetworkOrphans(c *C) {
+	now, _ := time.Parse(time.RFC3339, "2016-06-10T10:00:00Z")
+	created := "2016-06-10T01:00:00.000-07:00"
+	recent := "2016-06-10T02:55:00.000-07:00"
+	instance := "https://www.googleapis.com/compute/v1/projects/foo/zones/bar/instances/qux"
+	deleted := "https://www.googleapis.com/compute/v1/projects/foo/zones/bar/instances/baz"
+
+	r := &providers.NetworkResources{
+		TargetPools: []*compute.TargetPool{
+			{Name: "docker-network-a", Instances: []string{instance, deleted}, CreationTimestamp: created,
+				HealthChecks: []string{"https://www.googleapis.com/compute/v1/projects/foo/global/httpHealthChecks/docker-network-a"}},
+			{Name: "docker-network-b", Instances: []string{deleted}, CreationTimestamp: created,
+				HealthChecks: []string{"https://www.googleapis.com/compute/v1/projects/foo/global/httpHealthChecks/docker-network-b"}},
+			{Name: "docker-network-c", CreationTimestamp: recent},
+			{Name: "docker-network-f", CreationTimestamp: created},
+		},
+		RemoteTargetPools: []*compute.TargetPool{
+			{Name: "docker-network-e", Instances: []string{deleted}, CreationTimestamp: created,
+				HealthChecks: []string{"https://www.googleapis.com/compute/v1/projects/foo/global/httpHealthChecks/docker-network-e"}},
+		},
+		ForwardingRules: []*compute.ForwardingRule{
+			{Name: "docker-network-a-80-tcp", Target: "https://www.googleapis.com/compute/v1/projects/foo/regions/bar/targetPools/docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b-80-tcp", Target: "https://www.googleapis.com/compute/v1/projects/foo/regions/bar/targetPools/docker-network-b", CreationTimestamp: created},
+			{Name: "docker-network-d-80-tcp", Target: "https://www.googleapis.com/compute/v1/projects/foo/regions/bar/targetPools/docker-network-d", CreationTimestamp: created},
+		},
+		Firewalls: []*compute.Firewall{
+			{Name: "docker-network-a", TargetTags: []string{"docker-network-a"}, CreationTimestamp: created},
+			{Name: "docker-network-b", TargetTags: []string{"docker-network-b"}, CreationTimestamp: created},
+		},
+		HttpHealthChecks: []*compute.HttpHealthCheck{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", CreationTimestamp: created},
+			{Name: "docker-network-e", CreationTimestamp: created},
+		},
+		Instances: map[string][]string{
+			instance: {"docker-network-a", "docker-network-f"},
+		},
+	}
+
+	orphans := findNetworkOrphans(r, time.Hour, now)
+	c.Assert(orphans, DeepEquals, []providers.Resource{
+		{Kind: providers.KindForwardingRule, Name: "docker-network-b-80-tcp", CreationTimestamp: created},
+		{Kind: providers.KindForwardingRule, Name: "docker-network-d-80-tcp", CreationTimestamp: created},
+		{Kind: providers.KindTargetPool, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindFirewall, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindHttpHealthCheck, Name: "docker-network-b", CreationTimestamp: created},
+	})
+
+	orphans = findNetworkOrphans(r, 24*time.Hour, now)
+	c.Assert(orphans, HasLen, 0)
+}
+
+func (s *NetworkSuite) TestFindNetworkOrphansBackendServices(c *C) {
+	now, _ := time.Parse(time.RFC3339, "2016-06-10T10:00:00Z")
+	created := "2016-06-10T01:00:00.000-07:00"
+	base := "https://www.googleapis.com/compute/v1/projects/foo/"
+	zone := base + "zones/bar-a"
+
+	r := &providers.NetworkResources{
+		BackendServices: []*compute.BackendService{
+			{Name: "docker-network-a", CreationTimestamp: created,
+				Backends:     []*compute.Backend{{Group: zone + "/instanceGroups/docker-network-a"}},
+				HealthChecks: []string{base + "global/healthChecks/docker-network-a"}},
+			{Name: "docker-network-b", CreationTimestamp: created,
+				Backends:     []*compute.Backend{{Group: zone + "/instanceGroups/docker-network-b"}},
+				HealthChecks: []string{base + "global/healthChecks/docker-network-b"}},
+		},
+		RemoteBackendServices: []*compute.BackendService{
+			{Name: "docker-network-c", CreationTimestamp: created,
+				Backends:     []*compute.Backend{{Group: zone + "/instanceGroups/docker-network-c"}},
+				HealthChecks: []string{base + "global/healthChecks/docker-network-c"}},
+		},
+		ForwardingRules: []*compute.ForwardingRule{
+			{Name: "docker-network-a-80-tcp", BackendService: base + "regions/bar/backendServices/docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b-80-tcp", BackendService: base + "regions/bar/backendServices/docker-network-b", CreationTimestamp: created},
+		},
+		InstanceGroups: []*compute.InstanceGroup{
+			{Name: "docker-network-a", SelfLink: zone + "/instanceGroups/docker-network-a", Zone: zone, Size: 1, CreationTimestamp: created},
+			{Name: "docker-network-b", SelfLink: zone + "/instanceGroups/docker-network-b", Zone: zone, CreationTimestamp: created},
+			{Name: "docker-network-c", SelfLink: zone + "/instanceGroups/docker-network-c", Zone: zone, CreationTimestamp: created},
+		},
+		HealthChecks: []*compute.HealthCheck{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", CreationTimestamp: created},
+			{Name: "docker-network-c", CreationTimestamp: created},
+		},
+		RegionHealthChecks: []*compute.HealthCheck{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-d", CreationTimestamp: created},
+		},
+	}
+
+	orphans := findNetworkOrphans(r, time.Hour, now)
+	c.Assert(orphans, DeepEquals, []providers.Resource{
+		{Kind: providers.KindForwardingRule, Name: "docker-network-b-80-tcp", CreationTimestamp: created},
+		{Kind: providers.KindBackendService, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindHealthCheck, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindRegionHealthCheck, Name: "docker-network-d", CreationTimestamp: created},
+		{Kind: providers.KindInstanceGroup, Name: "docker-network-b", CreationTimestamp: created, Zone: "bar-a"},
+	})
+}
+
+func (s *NetworkSuite) TestFindNetworkOrphansGlobal(c *C) {
+	now, _ := time.Parse(time.RFC3339, "2016-06-10T10:00:00Z")
+	created := "2016-06-10T01:00:00.000-07:00"
+	base := "https://www.googleapis.com/compute/v1/projects/foo/"
+	zone := base + "zones/bar-a"
+
+	r := &providers.NetworkResources{
+		GlobalBackendServices: []*compute.BackendService{
+			{Name: "docker-network-a", CreationTimestamp: created,
+				Backends:       []*compute.Backend{{Group: zone + "/instanceGroups/docker-network-a"}},
+				HealthChecks:   []string{base + "global/healthChecks/docker-network-a"},
+				SecurityPolicy: base + "global/securityPolicies/docker-network-a"},
+			{Name: "docker-network-b", CreationTimestamp: created,
+				Backends:     []*compute.Backend{{Group: zone + "/instanceGroups/docker-network-b"}},
+				HealthChecks: []string{base + "global/healthChecks/docker-network-b"}},
+		},
+		UrlMaps: []*compute.UrlMap{
+			{Name: "docker-network-a", DefaultService: base + "global/backendServices/docker-network-b", CreationTimestamp: created,
+				PathMatchers: []*compute.PathMatcher{{
+					DefaultService: base + "global/backendServices/docker-network-b",
+					PathRules: []*compute.PathRule{
+						{Paths: []string{"/api/*"}, Service: base + "global/backendServices/docker-network-a"},
+					},
+				}}},
+			{Name: "docker-network-b", DefaultService: base + "global/backendServices/docker-network-b", CreationTimestamp: created},
+		},
+		TargetHttpsProxies: []*compute.TargetHttpsProxy{
+			{Name: "docker-network-a", UrlMap: base + "global/urlMaps/docker-network-a", CreationTimestamp: created,
+				SslCertificates: []string{base + "global/sslCertificates/docker-network-a"}},
+			{Name: "docker-network-b", UrlMap: base + "global/urlMaps/docker-network-b", CreationTimestamp: created,
+				SslCertificates: []string{base + "global/sslCertificates/docker-network-b"}},
+		},
+		GlobalForwardingRules: []*compute.ForwardingRule{
+			{Name: "docker-network-a", Target: base + "global/targetHttpsProxies/docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", Target: base + "global/targetHttpsProxies/docker-network-b", CreationTimestamp: created},
+			{Name: "docker-network-b-ipv6", Target: base + "global/targetHttpsProxies/docker-network-b", CreationTimestamp: created},
+		},
+		SslCertificates: []*compute.SslCertificate{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", CreationTimestamp: created},
+		},
+		SecurityPolicies: []*compute.SecurityPolicy{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", CreationTimestamp: created},
+		},
+		InstanceGroups: []*compute.InstanceGroup{
+			{Name: "docker-network-a", SelfLink: zone + "/instanceGroups/docker-network-a", Zone: zone, Size: 1, CreationTimestamp: created},
+			{Name: "docker-network-b", SelfLink: zone + "/instanceGroups/docker-network-b", Zone: zone, CreationTimestamp: created},
+		},
+		HealthChecks: []*compute.HealthCheck{
+			{Name: "docker-network-a", CreationTimestamp: created},
+			{Name: "docker-network-b", CreationTimestamp: created},
+		},
+		Addresses: []*compute.Address{
+			{Name: "api", Status: "IN_USE", CreationTimestamp: created},
+			{Name: "web", Status: "RESERVED", CreationTimestamp: created},
+		},
+		GlobalAddresses: []*compute.Address{
+			{Name: "www", Status: "RESERVED", CreationTimestamp: created},
+		},
+	}
+
+	orphans := findNetworkOrphans(r, time.Hour, now)
+	c.Assert(orphans, DeepEquals, []providers.Resource{
+		{Kind: providers.KindGlobalForwardingRule, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindGlobalForwardingRule, Name: "docker-network-b-ipv6", CreationTimestamp: created},
+		{Kind: providers.KindTargetHttpsProxy, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindUrlMap, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindGlobalBackendService, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindSslCertificate, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindSecurityPolicy, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindHealthCheck, Name: "docker-network-b", CreationTimestamp: created},
+		{Kind: providers.KindInstanceGroup, Name: "docker-network-b", CreationTimestamp: created, Zone: "bar-a"},
+		{Kind: providers.KindAddress, Name: "web", CreationTimestamp: created},
+		{Kind: providers.KindGlobalAddress, Name: "www", CreationTimestamp: created},
+	})
+
+	orphans = findNetworkOrphans(r, 24*time.Hour, now)
+	c.Assert(orphans, HasLen, 0)
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/bloomapi/gce-docker/collector"
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/bloomapi/gce-docker/watcher"
+	"github.com/spf13/cobra"
+)
+
+var (
+	DefaultGCRetention = 7 * 24 * time.Hour
+	DefaultGCMinAge    = time.Hour
+)
+
+type GCCommand struct {
+	DryRun    bool
+	Retention time.Duration
+	Snapshot  bool
+	MinAge    time.Duration
+
+	root *RootCommand
+}
+
+func NewGCCommand(root *RootCommand) *GCCommand {
+	return &GCCommand{root: root}
+}
+
+func (c *GCCommand) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "collect the orphaned resources created by gce-docker",
+	}
+
+	cmd.PersistentFlags().BoolVar(&c.DryRun, "dry-run", false, "only report the orphaned resources")
+
+	disks := &cobra.Command{
+		Use:   "disks",
+		Short: "collect the disks without users, docker volume or recent use",
+		RunE:  c.Disks,
+	}
+
+	disks.Flags().DurationVar(&c.Retention, "retention", DefaultGCRetention, "time since the last use before a disk is collected")
+	disks.Flags().BoolVar(&c.Snapshot, "snapshot", true, "snapshot the disks before deleting them")
+	cmd.AddCommand(disks)
+
+	network := &cobra.Command{
+		Use:   "network",
+		Short: "collect the load balancer resources without instance or container",
+		RunE:  c.Network,
+	}
+
+	network.Flags().DurationVar(&c.MinAge, "min-age", DefaultGCMinAge, "minimum age before a resource is collected")
+	cmd.AddCommand(network)
+
+	return cmd
+}
+
+func (c *GCCommand) Disks(cmd *cobra.Command, args []string) error {
+	if err := c.root.setup(); err != nil {
+		return err
+	}
+
+	if err := c.root.buildDockerClient(); err != nil {
+		return err
+	}
+
+	p, err := providers.NewDisk(c.root.client, c.root.project, c.root.zone, c.root.instance)
+	if err != nil {
+		return fmt.Errorf("error creating disk provider: %s", err)
+	}
+
+	gc := collector.NewDiskCollector(p, c.root.docker)
+	gc.DryRun = c.DryRun
+	gc.Retention = c.Retention
+	gc.Snapshot = c.Snapshot
+
+	disks, err := gc.Collect()
+	if err != nil {
+		return err
+	}
+
+	log15.Info("disks collected", "orphans", len(disks), "dry-run", c.DryRun)
+	return nil
+}
+
+func (c *GCCommand) Network(cmd *cobra.Command, args []string) error {
+	if err := c.root.setup(); err != nil {
+		return err
+	}
+
+	if err := c.root.buildDockerClient(); err != nil {
+		return err
+	}
+
+	w, err := watcher.NewWatcher(c.root.docker, c.root.client, c.root.project, c.root.zone, c.root.instance)
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %s", err)
+	}
+
+	active, err := w.ActiveConfigs()
+	if err != nil {
+		return fmt.Errorf("error listing containers: %s", err)
+	}
+
+	p, err := providers.NewNetwork(c.root.client, c.root.project, c.root.zone, c.root.instance)
+	if err != nil {
+		return fmt.Errorf("error creating network provider: %s", err)
+	}
+
+	gc := collector.NewNetworkCollector(p)
+	gc.DryRun = c.DryRun
+	gc.MinAge = c.MinAge
+
+	resources, err := gc.Collect(active)
+	if err != nil {
+		return err
+	}
+
+	log15.Info("network resources collected", "orphans", len(resources), "dry-run", c.DryRun)
+	return nil
+}
@@ -4,30 +4,41 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
 
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/storage/v1"
 	"cloud.google.com/go/compute/metadata"
 
 	"gopkg.in/inconshreveable/log15.v2"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/fsouza/go-dockerclient"
+	"github.com/bloomapi/gce-docker/collector"
 	"github.com/bloomapi/gce-docker/plugin"
+	"github.com/bloomapi/gce-docker/providers"
 	"github.com/bloomapi/gce-docker/watcher"
 	"github.com/spf13/cobra"
 )
 
 type RootCommand struct {
-	LogLevel string
-	LogFile  string
+	LogLevel     string
+	LogFile      string
+	Ephemeral    bool
+	EphemeralTTL time.Duration
+	GCInterval   time.Duration
+	GCRetention  time.Duration
+	GCSnapshot   bool
 
 	project  string
 	zone     string
 	instance string
 	client   *http.Client
+	docker   *docker.Client
 }
 
 func NewRootCommand() *RootCommand {
@@ -41,26 +52,33 @@ func (c *RootCommand) Command() *cobra.Command {
 		RunE:  c.Execute,
 	}
 
-	cmd.Flags().StringVar(&c.LogFile, "log-file", "", "log file")
-	cmd.Flags().StringVar(&c.LogLevel, "log-level", "info", "max log level enabled")
+	cmd.PersistentFlags().StringVar(&c.LogFile, "log-file", "", "log file")
+	cmd.PersistentFlags().StringVar(&c.LogLevel, "log-level", "info", "max log level enabled")
+	cmd.Flags().BoolVar(&c.Ephemeral, "ephemeral", false, "anonymous volumes created implicitly by the containers are ephemeral")
+	cmd.Flags().DurationVar(&c.EphemeralTTL, "ephemeral-ttl", 0, "grace period before removing an ephemeral volume after the last unmount")
+	cmd.Flags().DurationVar(&c.GCInterval, "gc-interval", 0, "interval between collections of orphaned disks, disabled by default")
+	cmd.Flags().DurationVar(&c.GCRetention, "gc-retention", DefaultGCRetention, "time since the last use before an orphaned disk is collected")
+	cmd.Flags().BoolVar(&c.GCSnapshot, "gc-snapshot", true, "snapshot the orphaned disks before deleting them")
+	cmd.AddCommand(NewVolumeCommand(c).Command())
+	cmd.AddCommand(NewGCCommand(c).Command())
 	return cmd
 }
 
 func (c *RootCommand) Execute(cmd *cobra.Command, args []string) error {
-	if err := c.checkGCE(); err != nil {
-		return err
-	}
-
-	if err := c.loadMetadataInfo(); err != nil {
+	if err := c.setup(); err != nil {
 		return err
 	}
 
-	if err := c.setupLogging(); err != nil {
+	if err := c.buildDockerClient(); err != nil {
 		return err
 	}
 
-	if err := c.buildComputeClient(); err != nil {
-		return err
+	if c.GCInterval != 0 {
+		go func() {
+			if err := c.runDiskCollector(); err != nil {
+				log15.Crit(err.Error())
+			}
+		}()
 	}
 
 	go func() {
@@ -79,6 +97,22 @@ func (c *RootCommand) Execute(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func (c *RootCommand) setup() error {
+	if err := c.checkGCE(); err != nil {
+		return err
+	}
+
+	if err := c.loadMetadataInfo(); err != nil {
+		return err
+	}
+
+	if err := c.setupLogging(); err != nil {
+		return err
+	}
+
+	return c.buildComputeClient()
+}
+
 func (c *RootCommand) checkGCE() error {
 	if !metadata.OnGCE() {
 		return fmt.Errorf("gce-docker driver only runs on Google Compute Engine")
@@ -134,7 +168,9 @@ func (c *RootCommand) buildComputeClient() error {
 	ctx := context.Background()
 
 	var err error
-	c.client, err = google.DefaultClient(ctx, compute.ComputeScope)
+	c.client, err = google.DefaultClient(ctx,
+		compute.ComputeScope, storage.DevstorageReadWriteScope, dns.NdevClouddnsReadwriteScope,
+	)
 	if err != nil {
 		return fmt.Errorf("error building compute client: %s", err)
 	}
@@ -142,14 +178,19 @@ func (c *RootCommand) buildComputeClient() error {
 	return nil
 }
 
-func (c *RootCommand) runWatcher() error {
-	log15.Info("starting watcher", "project", c.project, "zone", c.zone, "instance", c.instance)
-	d, err := docker.NewClientFromEnv()
+func (c *RootCommand) buildDockerClient() error {
+	var err error
+	c.docker, err = docker.NewClientFromEnv()
 	if err != nil {
 		return fmt.Errorf("error creating docker client: %s", err)
 	}
 
-	w, err := watcher.NewWatcher(d, c.client, c.project, c.zone, c.instance)
+	return nil
+}
+
+func (c *RootCommand) runWatcher() error {
+	log15.Info("starting watcher", "project", c.project, "zone", c.zone, "instance", c.instance)
+	w, err := watcher.NewWatcher(c.docker, c.client, c.project, c.zone, c.instance)
 	if err != nil {
 		return fmt.Errorf("error creating watcher: %s", err)
 	}
@@ -168,6 +209,9 @@ func (c *RootCommand) runVolumePlugin() error {
 		return fmt.Errorf("error creating volume plugin: %s", err)
 	}
 
+	d.EphemeralDefault = c.Ephemeral
+	d.EphemeralTTL = c.EphemeralTTL
+
 	h := volume.NewHandler(d)
 	if err := h.ServeUnix("docker", "gce"); err != nil {
 		return fmt.Errorf("error starting volume driver server: %s", err)
@@ -176,6 +220,21 @@ func (c *RootCommand) runVolumePlugin() error {
 	return nil
 }
 
+func (c *RootCommand) runDiskCollector() error {
+	log15.Info("starting disk collector", "interval", c.GCInterval, "retention", c.GCRetention)
+	p, err := providers.NewDisk(c.client, c.project, c.zone, c.instance)
+	if err != nil {
+		return fmt.Errorf("error creating disk provider: %s", err)
+	}
+
+	gc := collector.NewDiskCollector(p, c.docker)
+	gc.Retention = c.GCRetention
+	gc.Snapshot = c.GCSnapshot
+	gc.Run(c.GCInterval)
+
+	return nil
+}
+
 var RootCmd = NewRootCommand().Command()
 
 func Execute() {
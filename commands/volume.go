@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/bloomapi/gce-docker/plugin"
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/spf13/cobra"
+)
+
+type VolumeCommand struct {
+	root *RootCommand
+}
+
+func NewVolumeCommand(root *RootCommand) *VolumeCommand {
+	return &VolumeCommand{root: root}
+}
+
+func (c *VolumeCommand) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "volume",
+		Short: "manage the gce volumes",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "export <name> gs://<bucket>/<object>",
+		Short: "export the content of a volume to Cloud Storage as a compressed tarball",
+		RunE:  c.Export,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "import <name> gs://<bucket>/<object>",
+		Short: "import the content of a volume from a compressed tarball at Cloud Storage",
+		RunE:  c.Import,
+	})
+
+	return cmd
+}
+
+func (c *VolumeCommand) Export(cmd *cobra.Command, args []string) error {
+	v, s, err := c.setup(args)
+	if err != nil {
+		return err
+	}
+
+	name, url := args[0], args[1]
+	log15.Info("exporting volume", "volume", name, "url", url)
+	start := time.Now()
+
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.CloseWithError(v.Export(name, w))
+	}()
+
+	p := newProgressReader(r, 0)
+	o, err := s.Upload(url, p)
+	p.Done()
+
+	// the volume is unmounted by the export before returning
+	r.CloseWithError(err)
+	<-done
+
+	if err != nil {
+		return fmt.Errorf("error exporting volume %q: %s", name, err)
+	}
+
+	log15.Info("volume exported",
+		"volume", name, "url", url, "size", o.Size, "crc32c", o.Crc32c, "elapsed", time.Since(start),
+	)
+
+	return nil
+}
+
+func (c *VolumeCommand) Import(cmd *cobra.Command, args []string) error {
+	v, s, err := c.setup(args)
+	if err != nil {
+		return err
+	}
+
+	name, url := args[0], args[1]
+	log15.Info("importing volume", "volume", name, "url", url)
+	start := time.Now()
+
+	r, o, err := s.Download(url)
+	if err != nil {
+		return fmt.Errorf("error downloading %q: %s", url, err)
+	}
+
+	defer r.Close()
+
+	p := newProgressReader(r, int64(o.Size))
+	err = v.Import(name, p)
+	p.Done()
+	if err != nil {
+		return fmt.Errorf("error importing volume %q: %s", name, err)
+	}
+
+	log15.Info("volume imported",
+		"volume", name, "url", url, "size", o.Size, "crc32c", o.Crc32c, "elapsed", time.Since(start),
+	)
+
+	return nil
+}
+
+func (c *VolumeCommand) setup(args []string) (*plugin.Volume, *providers.Storage, error) {
+	if len(args) != 2 {
+		return nil, nil, fmt.Errorf("expected a volume name and a gs:// URL")
+	}
+
+	if _, _, err := providers.ParseStorageURL(args[1]); err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.root.setup(); err != nil {
+		return nil, nil, err
+	}
+
+	v, err := plugin.NewVolume(c.root.client, c.root.project, c.root.zone, c.root.instance)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating volume plugin: %s", err)
+	}
+
+	s, err := providers.NewStorage(c.root.client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating storage client: %s", err)
+	}
+
+	return v, s, nil
+}
+
+var ProgressInterval = time.Second
+
+// progressReader prints to stderr the amount of bytes read periodically.
+type progressReader struct {
+	io.Reader
+	total   int64
+	read    int64
+	printed time.Time
+}
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	return &progressReader{Reader: r, total: total}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if time.Since(r.printed) > ProgressInterval {
+		r.print()
+	}
+
+	return n, err
+}
+
+func (r *progressReader) Done() {
+	r.print()
+	fmt.Fprintln(os.Stderr)
+}
+
+func (r *progressReader) print() {
+	r.printed = time.Now()
+	if r.total == 0 {
+		fmt.Fprintf(os.Stderr, "\r%d bytes transferred", r.read)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\r%d/%d bytes transferred (%d%%)", r.read, r.total, r.read*100/r.total)
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Export writes to w a gzip compressed tar of the content of the volume, the
+// volume is mounted during the export if it isn't already.
+func (v *Volume) Export(name string, w io.Writer) error {
+	mnt, unmount, err := v.ensureMounted(name)
+	if err != nil {
+		return err
+	}
+
+	defer unmount()
+	return v.fs.Archive(mnt, w)
+}
+
+// Import extracts into the volume the gzip compressed tar read from r, the
+// volume is created if doesn't exists and mounted during the import if it
+// isn't already. The content is read until EOF into a temporary file before
+// extracting it, so any error validating it leaves the volume untouched.
+func (v *Volume) Import(name string, r io.Reader) error {
+	tmp, err := ioutil.TempFile("", "gce-docker-import")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return err
+	}
+
+	if resp := v.Get(volume.Request{Name: name}); resp.Volume == nil {
+		if resp := v.Create(volume.Request{Name: name}); resp.Err != "" {
+			return errors.New(resp.Err)
+		}
+	}
+
+	mnt, unmount, err := v.ensureMounted(name)
+	if err != nil {
+		return err
+	}
+
+	defer unmount()
+	return v.fs.Extract(mnt, tmp)
+}
+
+func (v *Volume) ensureMounted(name string) (string, func(), error) {
+	r := volume.Request{Name: name}
+	config, err := v.createDiskConfig(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mnt := config.MountPoint(v.Root)
+	if v.fs.IsMounted(mnt) {
+		return mnt, func() {}, nil
+	}
+
+	// the temporary mount doesn't remove an ephemeral volume, unless it was
+	// already scheduled for removal
+	resp, canceled := v.mount(r)
+	if resp.Err != "" {
+		return "", nil, errors.New(resp.Err)
+	}
+
+	return mnt, func() {
+		if resp := v.unmount(r, canceled); resp.Err != "" {
+			log15.Error("error unmounting volume", "volume", name, "error", resp.Err)
+		}
+	}, nil
+}
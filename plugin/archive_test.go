@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+type ArchiveSuite struct {
+	v  *Volume
+	fs *MemFilesystem
+	p  *DiskProviderFixture
+}
+
+var _ = Suite(&ArchiveSuite{})
+
+func (s *ArchiveSuite) SetUpTest(c *C) {
+	s.fs = NewMemFilesystem()
+	s.p = NewDiskProviderFixture()
+	s.v = &Volume{p: s.p, fs: s.fs, Root: "/mnt/"}
+}
+
+func (s *ArchiveSuite) TestExport(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	s.fs.Archives["/mnt/foo"] = "foo"
+
+	var buf bytes.Buffer
+	c.Assert(s.v.Export("foo", &buf), IsNil)
+	c.Assert(buf.String(), Equals, "foo")
+	c.Assert(s.p.attached, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+}
+
+func (s *ArchiveSuite) TestExportMounted(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	var buf bytes.Buffer
+	c.Assert(s.v.Export("foo", &buf), IsNil)
+	c.Assert(s.p.attached, HasLen, 1)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Not(Equals), "")
+}
+
+func (s *ArchiveSuite) TestExportEphemeral(c *C) {
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Ephemeral": "true"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+
+	var buf bytes.Buffer
+	c.Assert(s.v.Export("foo", &buf), IsNil)
+	c.Assert(s.p.attached, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, true)
+}
+
+func (s *ArchiveSuite) TestImport(c *C) {
+	c.Assert(s.v.Import("foo", bytes.NewBufferString("bar")), IsNil)
+	c.Assert(s.p.disks["foo"], Equals, true)
+	c.Assert(s.fs.Archives["/mnt/foo"], Equals, "bar")
+	c.Assert(s.p.attached, HasLen, 0)
+}
+
+type failingReader struct {
+	io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+
+	return n, err
+}
+
+func (s *ArchiveSuite) TestImportChecksumMismatch(c *C) {
+	r := &failingReader{Reader: bytes.NewBufferString("bar"), err: errors.New("checksum mismatch")}
+	c.Assert(s.v.Import("foo", r), ErrorMatches, "checksum mismatch")
+	c.Assert(s.p.disks["foo"], Equals, false)
+	c.Assert(s.fs.Archives, HasLen, 0)
+}
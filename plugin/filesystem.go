@@ -1,7 +1,9 @@
 package plugin
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os/exec"
 	"strings"
@@ -23,6 +25,12 @@ type Filesystem interface {
 	Mount(source string, target string) error
 	Unmount(target string) error
 	Format(source string) error
+	Wipe(source string) error
+	Assemble(target string, sources []string) error
+	Disassemble(target string) error
+	IsMounted(target string) bool
+	Archive(source string, w io.Writer) error
+	Extract(target string, r io.Reader) error
 }
 
 type OSFilesystem struct {
@@ -141,6 +149,224 @@ func (fs *OSFilesystem) getMkfsExt4Args(source string) []string {
 	return args
 }
 
+func (fs *OSFilesystem) Wipe(source string) error {
+	args := fs.getWipefsArgs(source)
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"wipefs failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	args = fs.getBlkdiscardArgs(source)
+	command = exec.Command(args[0], args[1:]...)
+	output, err = command.CombinedOutput()
+	if err != nil {
+		log15.Warn("blkdiscard failed", "source", source, "output", string(output))
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getWipefsArgs(source string) []string {
+	var args []string
+	args = append(args, "wipefs", "--all", source)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+func (fs *OSFilesystem) getBlkdiscardArgs(source string) []string {
+	var args []string
+	args = append(args, "blkdiscard", source)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+// Assemble combines the sources in a RAID0 array available at target, the
+// array is created if the sources don't belong to an array yet.
+func (fs *OSFilesystem) Assemble(target string, sources []string) error {
+	args := fs.getMdadmAssembleArgs(target, sources)
+	if !fs.isRAIDMember(sources[0]) {
+		args = fs.getMdadmCreateArgs(target, sources)
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"mdadm failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getMdadmCreateArgs(target string, sources []string) []string {
+	var args []string
+	args = append(args, "mdadm", "--create", target, "--run", "--level=0")
+	args = append(args, fmt.Sprintf("--raid-devices=%d", len(sources)))
+	args = append(args, sources...)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+func (fs *OSFilesystem) getMdadmAssembleArgs(target string, sources []string) []string {
+	var args []string
+	args = append(args, "mdadm", "--assemble", target)
+	args = append(args, sources...)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+func (fs *OSFilesystem) Disassemble(target string) error {
+	args := fs.getMdadmStopArgs(target)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"mdadm failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getMdadmStopArgs(target string) []string {
+	var args []string
+	args = append(args, "mdadm", "--stop", target)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+func (fs *OSFilesystem) isRAIDMember(source string) bool {
+	args := fs.getMdadmExamineArgs(source)
+
+	command := exec.Command(args[0], args[1:]...)
+	_, err := command.CombinedOutput()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (fs *OSFilesystem) getMdadmExamineArgs(source string) []string {
+	var args []string
+	args = append(args, "mdadm", "--examine", source)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+func (fs *OSFilesystem) IsMounted(target string) bool {
+	args := fs.getMountpointArgs(target)
+
+	command := exec.Command(args[0], args[1:]...)
+	_, err := command.CombinedOutput()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (fs *OSFilesystem) getMountpointArgs(target string) []string {
+	var args []string
+	args = append(args, "mountpoint", "-q", target)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+// Archive writes to w a gzip compressed tar of the content of source.
+func (fs *OSFilesystem) Archive(source string, w io.Writer) error {
+	args := fs.getTarCreateArgs(source)
+
+	var stderr bytes.Buffer
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdout = w
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf(
+			"tar failed, arguments: %q\noutput: %s\n",
+			args, stderr.String(),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getTarCreateArgs(source string) []string {
+	var args []string
+	args = append(args, "tar", "--create", "--gzip", "--file=-", "--directory", source, ".")
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
+// Extract extracts at target the gzip compressed tar read from r.
+func (fs *OSFilesystem) Extract(target string, r io.Reader) error {
+	args := fs.getTarExtractArgs(target)
+
+	var stderr bytes.Buffer
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin = r
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf(
+			"tar failed, arguments: %q\noutput: %s\n",
+			args, stderr.String(),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getTarExtractArgs(target string) []string {
+	var args []string
+	args = append(args, "tar", "--extract", "--gzip", "--file=-", "--directory", target)
+
+	if fs.inContainer {
+		return append(nsenterArgs, args...)
+	}
+
+	return args
+}
+
 func (fs *OSFilesystem) isFormatted(source string) bool {
 	args := fs.getBlkidArgs(source)
 
@@ -5,11 +5,13 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bloomapi/gce-docker/providers"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"google.golang.org/api/compute/v1"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -17,8 +19,35 @@ var WaitStatusTimeout = 100 * time.Second
 
 type Volume struct {
 	Root string
-	p    providers.DiskProvider
-	fs   Filesystem
+	// EphemeralDefault makes ephemeral the anonymous volumes created
+	// implicitly by the containers.
+	EphemeralDefault bool
+	// EphemeralTTL is the grace period after the last unmount of an ephemeral
+	// volume before is removed.
+	EphemeralTTL time.Duration
+
+	p   providers.DiskProvider
+	ssd providers.DiskProvider
+	fs  Filesystem
+
+	volumes map[string]*volumeState
+	// afterFunc schedules the removal of the ephemeral volumes, returning the
+	// function to cancel it, defaults to time.AfterFunc.
+	afterFunc func(time.Duration, func()) func() bool
+	sync.Mutex
+}
+
+// volumeState holds the mounts and the scheduled removal of a volume. Its
+// lock serializes the operations of the volume, the ones of different volumes
+// run concurrently.
+type volumeState struct {
+	mounts  int
+	removal *removal
+	sync.Mutex
+}
+
+type removal struct {
+	cancel func() bool
 }
 
 func NewVolume(c *http.Client, project, zone, instance string) (*Volume, error) {
@@ -27,10 +56,12 @@ func NewVolume(c *http.Client, project, zone, instance string) (*Volume, error)
 		return nil, err
 	}
 
+	fs := NewFilesystem()
 	return &Volume{
 		Root: "/mnt/",
 		p:    p,
-		fs:   NewFilesystem(),
+		ssd:  providers.NewLocalSSD(fs),
+		fs:   fs,
 	}, nil
 }
 
@@ -42,17 +73,28 @@ func (v *Volume) Create(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
-	if err := v.p.Create(config); err != nil {
+	if len(r.Options) == 0 && v.EphemeralDefault && isAnonymous(r.Name) {
+		config.Ephemeral = true
+	}
+
+	p, err := v.provider(config)
+	if err != nil {
 		return buildReponseError(err)
 	}
 
+	for _, d := range v.disks(config) {
+		if err := p.Create(d); err != nil {
+			return buildReponseError(err)
+		}
+	}
+
 	log15.Info("disk created", "disk", r.Name, "elapsed", time.Since(start))
 	return volume.Response{}
 }
 
 func (v *Volume) List(volume.Request) volume.Response {
 	log15.Debug("list request received")
-	disks, err := v.p.List()
+	disks, err := v.list()
 	if err != nil {
 		return buildReponseError(err)
 	}
@@ -80,7 +122,7 @@ func (v *Volume) Capabilities(volume.Request) volume.Response {
 
 func (v *Volume) Get(r volume.Request) volume.Response {
 	log15.Debug("get request received")
-	disks, err := v.p.List()
+	disks, err := v.list()
 	if err != nil {
 		return buildReponseError(err)
 	}
@@ -114,7 +156,11 @@ func (v *Volume) Remove(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
-	if err := v.p.Delete(config); err != nil {
+	state := v.state(r.Name)
+	defer state.Unlock()
+	v.cancelRemoval(state, config)
+
+	if err := v.remove(config); err != nil {
 		return buildReponseError(err)
 	}
 
@@ -122,6 +168,31 @@ func (v *Volume) Remove(r volume.Request) volume.Response {
 	return volume.Response{}
 }
 
+func (v *Volume) remove(c *providers.DiskConfig) error {
+	p, err := v.provider(c)
+	if err != nil {
+		return err
+	}
+
+	if c.IsLocalSSD() {
+		if err := v.wipe(p, c); err != nil {
+			return err
+		}
+	}
+
+	if err := v.loadLabels(c); err != nil {
+		return err
+	}
+
+	for _, d := range v.disks(c) {
+		if err := p.Delete(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (v *Volume) Path(r volume.Request) volume.Response {
 	config, err := v.createDiskConfig(r)
 	if err != nil {
@@ -139,34 +210,61 @@ func (v *Volume) Path(r volume.Request) volume.Response {
 }
 
 func (v *Volume) Mount(r volume.Request) volume.Response {
+	resp, _ := v.mount(r)
+	return resp
+}
+
+// mount mounts the volume, returns if a scheduled removal of the volume was
+// canceled.
+func (v *Volume) mount(r volume.Request) (volume.Response, bool) {
 	log15.Debug("mount request received", "name", r.Name)
 	start := time.Now()
 
 	config, err := v.createDiskConfig(r)
 	if err != nil {
-		return buildReponseError(err)
+		return buildReponseError(err), false
+	}
+
+	state := v.state(r.Name)
+	defer state.Unlock()
+	canceled := v.cancelRemoval(state, config)
+
+	if state.mounts > 0 {
+		state.mounts++
+		log15.Debug("disk already mounted", "disk", r.Name, "mounts", state.mounts)
+		return volume.Response{Mountpoint: config.MountPoint(v.Root)}, canceled
 	}
 
 	if err := v.createMountPoint(config); err != nil {
-		return buildReponseError(err)
+		return buildReponseError(err), canceled
 	}
 
-	if err := v.p.Attach(config); err != nil {
-		return buildReponseError(err)
+	p, err := v.provider(config)
+	if err != nil {
+		return buildReponseError(err), canceled
+	}
+
+	if err := v.loadLabels(config); err != nil {
+		return buildReponseError(err), canceled
+	}
+
+	if err := v.attach(p, config); err != nil {
+		return buildReponseError(err), canceled
 	}
 
 	if err := v.fs.Format(config.Dev()); err != nil {
-		return buildReponseError(err)
+		return buildReponseError(err), canceled
 	}
 
 	if err := v.fs.Mount(config.Dev(), config.MountPoint(v.Root)); err != nil {
-		return buildReponseError(err)
+		return buildReponseError(err), canceled
 	}
 
+	state.mounts = 1
 	log15.Info("disk mounted", "disk", r.Name, "elapsed", time.Since(start))
 	return volume.Response{
 		Mountpoint: config.MountPoint(v.Root),
-	}
+	}, canceled
 }
 
 func (v *Volume) createMountPoint(c *providers.DiskConfig) error {
@@ -188,6 +286,12 @@ func (v *Volume) createMountPoint(c *providers.DiskConfig) error {
 }
 
 func (v *Volume) Unmount(r volume.Request) volume.Response {
+	return v.unmount(r, true)
+}
+
+// unmount unmounts the volume, an ephemeral volume is scheduled for removal
+// after the last unmount only if remove is true.
+func (v *Volume) unmount(r volume.Request, remove bool) volume.Response {
 	log15.Debug("unmount request received", "name", r.Name)
 	start := time.Now()
 	config, err := v.createDiskConfig(r)
@@ -195,18 +299,330 @@ func (v *Volume) Unmount(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
+	state := v.state(r.Name)
+	defer state.Unlock()
+
+	if state.mounts > 1 {
+		state.mounts--
+		log15.Debug("disk still in use", "disk", r.Name, "mounts", state.mounts)
+		return volume.Response{}
+	}
+
 	if err := v.fs.Unmount(config.MountPoint(v.Root)); err != nil {
 		return buildReponseError(err)
 	}
 
-	if err := v.p.Detach(config); err != nil {
+	p, err := v.provider(config)
+	if err != nil {
 		return buildReponseError(err)
 	}
 
+	if err := v.loadLabels(config); err != nil {
+		return buildReponseError(err)
+	}
+
+	if err := v.detach(p, config); err != nil {
+		return buildReponseError(err)
+	}
+
+	state.mounts = 0
 	log15.Info("disk unmounted", "disk", r.Name, "elapsed", time.Since(start))
+
+	if config.Ephemeral && remove {
+		v.scheduleRemoval(state, config)
+	}
+
 	return volume.Response{}
 }
 
+// state returns the state of the volume, locked.
+func (v *Volume) state(name string) *volumeState {
+	v.Lock()
+	v.initState()
+	s, ok := v.volumes[name]
+	if !ok {
+		s = &volumeState{}
+		v.volumes[name] = s
+	}
+
+	v.Unlock()
+	s.Lock()
+	return s
+}
+
+func (v *Volume) initState() {
+	if v.volumes != nil {
+		return
+	}
+
+	v.volumes = make(map[string]*volumeState, 0)
+	if v.afterFunc == nil {
+		v.afterFunc = func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		}
+	}
+}
+
+// isAnonymous returns true if the name was generated by docker for a volume
+// without name, the only ones known to be created implicitly.
+func isAnonymous(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+
+	for _, r := range name {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scheduleRemoval removes an ephemeral volume after EphemeralTTL, the removal
+// is canceled if the volume is mounted again in the meantime. Should be
+// called holding the lock of the volume.
+func (v *Volume) scheduleRemoval(s *volumeState, c *providers.DiskConfig) {
+	if v.EphemeralTTL == 0 {
+		v.removeEphemeral(c)
+		return
+	}
+
+	log15.Info("ephemeral disk scheduled for removal", "disk", c.Name, "ttl", v.EphemeralTTL)
+
+	r := &removal{}
+	r.cancel = v.afterFunc(v.EphemeralTTL, func() {
+		state := v.state(c.Name)
+		defer state.Unlock()
+
+		if state.removal != r {
+			return
+		}
+
+		state.removal = nil
+		v.removeEphemeral(c)
+	})
+
+	s.removal = r
+}
+
+// cancelRemoval cancels the scheduled removal of the volume, if any, and
+// returns if there was one. Should be called holding the lock of the volume.
+func (v *Volume) cancelRemoval(s *volumeState, c *providers.DiskConfig) bool {
+	if s.removal == nil {
+		return false
+	}
+
+	s.removal.cancel()
+	s.removal = nil
+	log15.Info("ephemeral disk removal canceled", "disk", c.Name)
+	return true
+}
+
+func (v *Volume) removeEphemeral(c *providers.DiskConfig) {
+	start := time.Now()
+	used, err := v.inUse(c)
+	if err != nil {
+		log15.Error("error removing ephemeral disk", "disk", c.Name, "error", err)
+		return
+	}
+
+	if used {
+		log15.Warn("ephemeral disk still attached, skipping removal", "disk", c.Name)
+		return
+	}
+
+	if err := v.remove(c); err != nil {
+		log15.Error("error removing ephemeral disk", "disk", c.Name, "error", err)
+		return
+	}
+
+	log15.Info("ephemeral disk removed", "disk", c.Name, "elapsed", time.Since(start))
+}
+
+// inUse returns true if any disk of the volume is still attached to an
+// instance, the mount counts are lost when the plugin is restarted, so the
+// attachments are the only reliable source before deleting a disk.
+func (v *Volume) inUse(c *providers.DiskConfig) (bool, error) {
+	if c.IsLocalSSD() {
+		return false, nil
+	}
+
+	disks, err := v.p.List()
+	if err != nil {
+		return false, err
+	}
+
+	names := make(map[string]bool, 0)
+	for _, d := range v.disks(c) {
+		names[d.Name] = true
+	}
+
+	for _, d := range disks {
+		if names[d.Name] && len(d.Users) != 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// provider returns the DiskProvider responsible of the given disk, the type
+// of the disk is only known at creation, so the local SSDs are looked up by
+// name in any other case.
+func (v *Volume) provider(c *providers.DiskConfig) (providers.DiskProvider, error) {
+	if v.ssd == nil {
+		if c.IsLocalSSD() {
+			return nil, fmt.Errorf("local SSDs are not supported")
+		}
+
+		return v.p, nil
+	}
+
+	if c.IsLocalSSD() {
+		return v.ssd, nil
+	}
+
+	disks, err := v.ssd.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range disks {
+		if d.Name == c.Name {
+			c.Type = providers.LocalSSDType
+			return v.ssd, nil
+		}
+	}
+
+	return v.p, nil
+}
+
+func (v *Volume) list() ([]*compute.Disk, error) {
+	disks, err := v.p.List()
+	if err != nil {
+		return nil, err
+	}
+
+	disks = groupStripes(disks)
+	if v.ssd == nil {
+		return disks, nil
+	}
+
+	ssds, err := v.ssd.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(disks, ssds...), nil
+}
+
+// groupStripes replaces the disks of every striped volume by a single disk
+// named as the volume.
+func groupStripes(disks []*compute.Disk) []*compute.Disk {
+	var grouped []*compute.Disk
+	seen := make(map[string]bool, 0)
+	for _, d := range disks {
+		if _, ok := d.Labels[providers.LabelStripes]; !ok {
+			grouped = append(grouped, d)
+			continue
+		}
+
+		name := d.Labels[providers.LabelVolume]
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		grouped = append(grouped, &compute.Disk{
+			Name:   name,
+			Status: d.Status,
+			Labels: d.Labels,
+		})
+	}
+
+	return grouped
+}
+
+// loadLabels sets the number of stripes and the ephemeral flag of a volume
+// from the labels of its disks, since the options are only provided at creation.
+func (v *Volume) loadLabels(c *providers.DiskConfig) error {
+	if c.IsLocalSSD() {
+		return nil
+	}
+
+	disks, err := v.p.List()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range disks {
+		if d.Labels[providers.LabelVolume] != c.Name {
+			continue
+		}
+
+		c.Ephemeral = d.Labels[providers.LabelEphemeral] == "true"
+		if stripes, ok := d.Labels[providers.LabelStripes]; ok {
+			c.Stripes, err = strconv.ParseInt(stripes, 10, 64)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// disks returns the configs of the disks backing a volume.
+func (v *Volume) disks(c *providers.DiskConfig) []*providers.DiskConfig {
+	if c.IsStriped() {
+		return c.StripeConfigs()
+	}
+
+	return []*providers.DiskConfig{c}
+}
+
+func (v *Volume) attach(p providers.DiskProvider, c *providers.DiskConfig) error {
+	var devs []string
+	for _, d := range v.disks(c) {
+		if err := p.Attach(d); err != nil {
+			return err
+		}
+
+		devs = append(devs, d.Dev())
+	}
+
+	if !c.IsStriped() {
+		return nil
+	}
+
+	return v.fs.Assemble(c.Dev(), devs)
+}
+
+func (v *Volume) detach(p providers.DiskProvider, c *providers.DiskConfig) error {
+	if c.IsStriped() {
+		if err := v.fs.Disassemble(c.Dev()); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range v.disks(c) {
+		if err := p.Detach(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wipe erases the content of a local SSD before is given back to the free set.
+func (v *Volume) wipe(p providers.DiskProvider, c *providers.DiskConfig) error {
+	if err := p.Attach(c); err != nil {
+		return err
+	}
+
+	return v.fs.Wipe(c.Dev())
+}
+
 func (v *Volume) createDiskConfig(r volume.Request) (*providers.DiskConfig, error) {
 	config := &providers.DiskConfig{Name: r.Name}
 
@@ -226,6 +642,18 @@ func (v *Volume) createDiskConfig(r volume.Request) (*providers.DiskConfig, erro
 			config.SourceSnapshot = value
 		case "SourceImage":
 			config.SourceImage = value
+		case "Ephemeral":
+			var err error
+			config.Ephemeral, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "Stripes":
+			var err error
+			config.Stripes, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
 		default:
 			return nil, fmt.Errorf("unknown option %q", key)
 		}
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
@@ -131,20 +133,227 @@ func (s *VolumeSuite) TestUnmount(c *C) {
 	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
 }
 
+func (s *VolumeSuite) TestMountTwice(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "/mnt/foo")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached, HasLen, 1)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Not(Equals), "")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+}
+
+func (s *VolumeSuite) TestEphemeral(c *C) {
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Ephemeral": "true"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["foo"]["gce-docker-ephemeral"], Equals, "true")
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestEphemeralDefault(c *C) {
+	s.v.EphemeralDefault = true
+
+	anonymous := "6e1cdb2ff3ad0cfd5c6a4d5bc4f0fb3e4dad1d1c4f7a7c7ba80fd4d3f2a1b0c9"
+	r := s.v.Create(volume.Request{Name: anonymous})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels[anonymous]["gce-docker-ephemeral"], Equals, "true")
+
+	r = s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["foo"]["gce-docker-ephemeral"], Equals, "")
+
+	r = s.v.Create(volume.Request{
+		Name:    "bar",
+		Options: map[string]string{"SizeGb": "42"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["bar"]["gce-docker-ephemeral"], Equals, "")
+}
+
+func (s *VolumeSuite) TestEphemeralTTL(c *C) {
+	var removals []func()
+	s.v.EphemeralTTL = time.Minute
+	s.v.afterFunc = func(d time.Duration, f func()) func() bool {
+		c.Assert(d, Equals, time.Minute)
+		removals = append(removals, f)
+		return func() bool { return true }
+	}
+
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Ephemeral": "true"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 1)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	c.Assert(removals, HasLen, 1)
+	removals[0]()
+	c.Assert(s.p.disks, HasLen, 1)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	c.Assert(removals, HasLen, 2)
+	removals[1]()
+	c.Assert(s.p.disks, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestEphemeralInUse(c *C) {
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Ephemeral": "true"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.p.users["foo"] = []string{"other-instance"}
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 1)
+}
+
+func (s *VolumeSuite) TestLockPerVolume(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	state := s.v.state("bar")
+	defer state.Unlock()
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestLocalSSD(c *C) {
+	dev := "/dev/disk/by-id/google-local-ssd-0"
+	c.Assert(afero.WriteFile(s.fs, dev, nil, 0644), IsNil)
+	s.v.ssd = providers.NewLocalSSD(s.fs)
+
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Type": "local-ssd"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 0)
+
+	r = s.v.Create(volume.Request{
+		Name:    "bar",
+		Options: map[string]string{"Type": "local-ssd"},
+	})
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Volumes, HasLen, 1)
+	c.Assert(r.Volumes[0].Name, Equals, "foo")
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, dev)
+	c.Assert(s.fs.Formatted[dev], Equals, "ext4")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Wiped[dev], Equals, true)
+
+	r = s.v.Create(volume.Request{
+		Name:    "bar",
+		Options: map[string]string{"Type": "local-ssd"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestStripes(c *C) {
+	r := s.v.Create(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Stripes": "2", "SizeGb": "100"},
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 2)
+	c.Assert(s.p.disks["foo-0"], Equals, true)
+	c.Assert(s.p.disks["foo-1"], Equals, true)
+
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Volumes, HasLen, 1)
+	c.Assert(r.Volumes[0].Name, Equals, "foo")
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached, HasLen, 2)
+	c.Assert(s.fs.Assembled["/dev/md/docker-volume-foo"], DeepEquals, []string{
+		"/dev/disk/by-id/google-docker-volume-foo-0",
+		"/dev/disk/by-id/google-docker-volume-foo-1",
+	})
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/md/docker-volume-foo")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached, HasLen, 0)
+	c.Assert(s.fs.Assembled, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 0)
+}
+
 type DiskProviderFixture struct {
 	disks    map[string]bool
+	labels   map[string]map[string]string
 	attached map[string]bool
+	users    map[string][]string
 }
 
 func NewDiskProviderFixture() *DiskProviderFixture {
 	return &DiskProviderFixture{
 		disks:    make(map[string]bool, 0),
+		labels:   make(map[string]map[string]string, 0),
 		attached: make(map[string]bool, 0),
+		users:    make(map[string][]string, 0),
 	}
 }
 
 func (d *DiskProviderFixture) Create(c *providers.DiskConfig) error {
 	d.disks[c.Name] = true
+	d.labels[c.Name] = c.Disk("project", "zone").Labels
 	return nil
 }
 
@@ -164,13 +373,23 @@ func (d *DiskProviderFixture) Detach(c *providers.DiskConfig) error {
 
 func (d *DiskProviderFixture) Delete(c *providers.DiskConfig) error {
 	delete(d.disks, c.Name)
+	delete(d.labels, c.Name)
+	return nil
+}
+
+func (d *DiskProviderFixture) Snapshot(c *providers.DiskConfig, snapshot string) error {
 	return nil
 }
 
 func (d *DiskProviderFixture) List() ([]*compute.Disk, error) {
 	var l []*compute.Disk
 	for name, _ := range d.disks {
-		l = append(l, &compute.Disk{Name: name, Status: "READY"})
+		l = append(l, &compute.Disk{
+			Name:   name,
+			Status: "READY",
+			Labels: d.labels[name],
+			Users:  d.users[name],
+		})
 	}
 
 	l = append(l, &compute.Disk{Name: "no-ready", Status: "PENDING"})
@@ -180,6 +399,9 @@ func (d *DiskProviderFixture) List() ([]*compute.Disk, error) {
 type MemFilesystem struct {
 	Mounted   map[string]string
 	Formatted map[string]string
+	Wiped     map[string]bool
+	Assembled map[string][]string
+	Archives  map[string]string
 	afero.Fs
 }
 
@@ -187,6 +409,9 @@ func NewMemFilesystem() *MemFilesystem {
 	return &MemFilesystem{
 		Mounted:   make(map[string]string, 0),
 		Formatted: make(map[string]string, 0),
+		Wiped:     make(map[string]bool, 0),
+		Assembled: make(map[string][]string, 0),
+		Archives:  make(map[string]string, 0),
 
 		Fs: afero.NewMemMapFs(),
 	}
@@ -206,3 +431,34 @@ func (fs *MemFilesystem) Format(source string) error {
 	fs.Formatted[source] = "ext4"
 	return nil
 }
+
+func (fs *MemFilesystem) Wipe(source string) error {
+	delete(fs.Formatted, source)
+	fs.Wiped[source] = true
+	return nil
+}
+
+func (fs *MemFilesystem) Assemble(target string, sources []string) error {
+	fs.Assembled[target] = sources
+	return nil
+}
+
+func (fs *MemFilesystem) Disassemble(target string) error {
+	delete(fs.Assembled, target)
+	return nil
+}
+
+func (fs *MemFilesystem) IsMounted(target string) bool {
+	return fs.Mounted[target] != ""
+}
+
+func (fs *MemFilesystem) Archive(source string, w io.Writer) error {
+	_, err := io.WriteString(w, fs.Archives[source])
+	return err
+}
+
+func (fs *MemFilesystem) Extract(target string, r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	fs.Archives[target] = string(content)
+	return err
+}
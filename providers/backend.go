@@ -0,0 +1,497 @@
+package providers
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// createOrUpdateInstanceGroup creates the unmanaged instance group of the
+// load balancer at the zone of the instance, and adds the instance. The named
+// ports of an existing group are updated when they differ from the config.
+func (n *Network) createOrUpdateInstanceGroup(c *NetworkConfig) (func() error, error) {
+	group := c.InstanceGroup(n.instance)
+	old, err := n.s.InstanceGroups.Get(n.project, n.zone, group.Name).Do()
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		op, err := n.s.InstanceGroups.Insert(n.project, n.zone, group).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		undo := func() error { return n.deleteInstanceGroup(c) }
+		if err := n.WaitDone(op); err != nil {
+			return undo, err
+		}
+
+		return undo, n.addGroupInstance(c)
+	}
+
+	var undos []func() error
+	undo := func() error {
+		for i := len(undos) - 1; i >= 0; i-- {
+			if err := undos[i](); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if !equalNamedPorts(old.NamedPorts, group.NamedPorts) {
+		log15.Info("instance group named ports outdated, updating", "group", group.Name)
+		if err := n.setNamedPorts(group.Name, group.NamedPorts, old.Fingerprint); err != nil {
+			return nil, err
+		}
+
+		undos = append(undos, func() error {
+			g, err := n.s.InstanceGroups.Get(n.project, n.zone, group.Name).Do()
+			if err != nil {
+				return err
+			}
+
+			return n.setNamedPorts(group.Name, old.NamedPorts, g.Fingerprint)
+		})
+	}
+
+	members, err := n.groupInstances(group.Name)
+	if err != nil {
+		return undo, err
+	}
+
+	if !contains(members, InstanceURL(n.project, n.zone, n.instance)) {
+		undos = append(undos, func() error {
+			_, err := n.removeGroupInstance(c)
+			return err
+		})
+
+		if err := n.addGroupInstance(c); err != nil {
+			return undo, err
+		}
+	}
+
+	if len(undos) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+func (n *Network) setNamedPorts(group string, ports []*compute.NamedPort, fingerprint string) error {
+	op, err := n.s.InstanceGroups.SetNamedPorts(n.project, n.zone, group, &compute.InstanceGroupsSetNamedPortsRequest{
+		NamedPorts:      ports,
+		Fingerprint:     fingerprint,
+		ForceSendFields: []string{"NamedPorts"},
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func equalNamedPorts(a, b []*compute.NamedPort) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Port != b[i].Port {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n *Network) addGroupInstance(c *NetworkConfig) error {
+	op, err := n.s.InstanceGroups.AddInstances(n.project, n.zone, c.Name(n.instance), &compute.InstanceGroupsAddInstancesRequest{
+		Instances: []*compute.InstanceReference{{
+			Instance: InstanceURL(n.project, n.zone, n.instance),
+		}},
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+// removeGroupInstance removes the instance from the instance group and returns
+// if the group is empty afterwards.
+func (n *Network) removeGroupInstance(c *NetworkConfig) (bool, error) {
+	name := c.Name(n.instance)
+	members, err := n.groupInstances(name)
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	instance := InstanceURL(n.project, n.zone, n.instance)
+	if contains(members, instance) {
+		op, err := n.s.InstanceGroups.RemoveInstances(n.project, n.zone, name, &compute.InstanceGroupsRemoveInstancesRequest{
+			Instances: []*compute.InstanceReference{{Instance: instance}},
+		}).Do()
+
+		if err != nil {
+			return false, err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return false, err
+		}
+	}
+
+	members, err = n.groupInstances(name)
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return len(members) == 0, nil
+}
+
+func (n *Network) groupInstances(name string) ([]string, error) {
+	var members []string
+	err := n.s.InstanceGroups.ListInstances(n.project, n.zone, name, &compute.InstanceGroupsListInstancesRequest{}).
+		Pages(context.Background(), func(l *compute.InstanceGroupsListInstances) error {
+			for _, i := range l.Items {
+				members = append(members, i.Instance)
+			}
+
+			return nil
+		})
+
+	return members, err
+}
+
+// createOrUpdateBackendHealthCheck creates the health check of the backend
+// service, or updates it when it differs from the config.
+func (n *Network) createOrUpdateBackendHealthCheck(c *NetworkConfig) (func() error, error) {
+	new := c.BackendHealthCheck(n.instance)
+	var old *compute.HealthCheck
+	var err error
+	if c.externalBackend() {
+		old, err = n.s.RegionHealthChecks.Get(n.project, n.region, new.Name).Do()
+	} else {
+		old, err = n.s.HealthChecks.Get(n.project, new.Name).Do()
+	}
+
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		var op *compute.Operation
+		if c.externalBackend() {
+			op, err = n.s.RegionHealthChecks.Insert(n.project, n.region, new).Do()
+		} else {
+			op, err = n.s.HealthChecks.Insert(n.project, new).Do()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteBackendHealthCheck(c) }, n.WaitDone(op)
+	}
+
+	if !isBackendHealthCheckOutdated(old, new) {
+		return nil, nil
+	}
+
+	log15.Info("health check outdated, updating", "check", new.Name)
+	var op *compute.Operation
+	if c.externalBackend() {
+		op, err = n.s.RegionHealthChecks.Update(n.project, n.region, new.Name, new).Do()
+	} else {
+		op, err = n.s.HealthChecks.Update(n.project, new.Name, new).Do()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, n.WaitDone(op)
+}
+
+// isBackendHealthCheckOutdated compares the type and port of the health
+// checks, along with the rest of fields set by the config.
+func isBackendHealthCheckOutdated(old, new *compute.HealthCheck) bool {
+	if old.Type != new.Type {
+		return true
+	}
+
+	if new.TcpHealthCheck != nil {
+		if old.TcpHealthCheck == nil || old.TcpHealthCheck.Port != new.TcpHealthCheck.Port {
+			return true
+		}
+	}
+
+	if new.HttpHealthCheck != nil {
+		if old.HttpHealthCheck == nil ||
+			old.HttpHealthCheck.Port != new.HttpHealthCheck.Port ||
+			old.HttpHealthCheck.RequestPath != new.HttpHealthCheck.RequestPath {
+			return true
+		}
+	}
+
+	return (new.CheckIntervalSec != 0 && old.CheckIntervalSec != new.CheckIntervalSec) ||
+		(new.HealthyThreshold != 0 && old.HealthyThreshold != new.HealthyThreshold) ||
+		(new.UnhealthyThreshold != 0 && old.UnhealthyThreshold != new.UnhealthyThreshold)
+}
+
+// createOrUpdateBackendService creates the backend service, or adds the
+// instance group of the zone as backend if the service already exists.
+func (n *Network) createOrUpdateBackendService(c *NetworkConfig) (func() error, error) {
+	service := c.BackendService(n.project, n.region, n.instance)
+	backend := c.Backend(n.project, n.zone, n.instance)
+
+	old, err := n.getBackendService(c)
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		service.Backends = []*compute.Backend{backend}
+		var op *compute.Operation
+		if c.Scheme == SchemeHTTPS {
+			op, err = n.s.BackendServices.Insert(n.project, service).Do()
+		} else {
+			op, err = n.s.RegionBackendServices.Insert(n.project, n.region, service).Do()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteBackendService(c) }, n.WaitDone(op)
+	}
+
+	if isConnectionDrainingOutdated(old, service) {
+		log15.Info("backend service connection draining outdated, patching", "service", service.Name)
+		op, err := n.patchBackendService(c, &compute.BackendService{
+			ConnectionDraining: service.ConnectionDraining,
+			Fingerprint:        old.Fingerprint,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return nil, err
+		}
+	}
+
+	added := false
+	err = n.updateBackends(c, func(backends []*compute.Backend) ([]*compute.Backend, bool) {
+		if containsBackend(backends, backend.Group) {
+			return backends, false
+		}
+
+		added = true
+		return append(backends, backend), true
+	})
+
+	if !added {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := n.removeBackend(c)
+		return err
+	}, err
+}
+
+// isConnectionDrainingOutdated compares the draining timeout of the service,
+// unless the config leaves it to the default of the API.
+func isConnectionDrainingOutdated(old, new *compute.BackendService) bool {
+	if new.ConnectionDraining == nil {
+		return false
+	}
+
+	return old.ConnectionDraining == nil ||
+		old.ConnectionDraining.DrainingTimeoutSec != new.ConnectionDraining.DrainingTimeoutSec
+}
+
+// removeBackend removes the instance group of the zone from the backend
+// service, and returns if the service has no backends afterwards.
+func (n *Network) removeBackend(c *NetworkConfig) (bool, error) {
+	group := InstanceGroupURL(n.project, n.zone, c.Name(n.instance))
+
+	var empty bool
+	err := n.updateBackends(c, func(backends []*compute.Backend) ([]*compute.Backend, bool) {
+		var remaining []*compute.Backend
+		for _, b := range backends {
+			if b.Group != group {
+				remaining = append(remaining, b)
+			}
+		}
+
+		empty = len(remaining) == 0
+		return remaining, len(remaining) != len(backends)
+	})
+
+	if err != nil && isNotFound(err) {
+		return true, nil
+	}
+
+	return empty, err
+}
+
+// updateBackends replaces the backends of the service by the ones returned by
+// update, if any change. The fingerprint of the service guards against
+// concurrent modifications, on conflict the update is retried.
+func (n *Network) updateBackends(c *NetworkConfig, update func([]*compute.Backend) ([]*compute.Backend, bool)) error {
+	name := c.Name(n.instance)
+	for retries := 0; ; retries++ {
+		s, err := n.getBackendService(c)
+		if err != nil {
+			return err
+		}
+
+		backends, changed := update(s.Backends)
+		if !changed {
+			return nil
+		}
+
+		op, err := n.patchBackendService(c, &compute.BackendService{
+			Backends:        backends,
+			Fingerprint:     s.Fingerprint,
+			ForceSendFields: []string{"Backends"},
+		})
+
+		if err == nil {
+			return n.WaitDone(op)
+		}
+
+		if !isConflict(err) || retries >= MaxConflictRetries {
+			return err
+		}
+
+		log15.Warn("backend service modified concurrently, retrying", "service", name, "retries", retries)
+		conflictBackoff(retries)
+	}
+}
+
+func (n *Network) getBackendService(c *NetworkConfig) (*compute.BackendService, error) {
+	if c.Scheme == SchemeHTTPS {
+		return n.s.BackendServices.Get(n.project, c.Name(n.instance)).Do()
+	}
+
+	return n.s.RegionBackendServices.Get(n.project, n.region, c.Name(n.instance)).Do()
+}
+
+func (n *Network) patchBackendService(c *NetworkConfig, s *compute.BackendService) (*compute.Operation, error) {
+	if c.Scheme == SchemeHTTPS {
+		return n.s.BackendServices.Patch(n.project, c.Name(n.instance), s).Do()
+	}
+
+	return n.s.RegionBackendServices.Patch(n.project, n.region, c.Name(n.instance), s).Do()
+}
+
+func containsBackend(backends []*compute.Backend, group string) bool {
+	for _, b := range backends {
+		if b.Group == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deleteBackends removes the instance from the instance group of its zone,
+// the group is deleted along with the last instance of the zone. Returns if
+// the backend service has no backends afterwards.
+func (n *Network) deleteBackends(c *NetworkConfig) (bool, error) {
+	empty, err := n.removeGroupInstance(c)
+	if err != nil {
+		return false, fmt.Errorf("error removing instance from instance group: %s", err)
+	}
+
+	if err := n.removeInstanceTag(c); err != nil {
+		return false, fmt.Errorf("error removing instance tag: %s", err)
+	}
+
+	if !empty {
+		return false, nil
+	}
+
+	unused, err := n.removeBackend(c)
+	if err != nil {
+		return false, fmt.Errorf("error removing backend from backend service: %s", err)
+	}
+
+	if err := n.deleteInstanceGroup(c); err != nil {
+		return false, fmt.Errorf("error deleting instance group: %s", err)
+	}
+
+	return unused, nil
+}
+
+func (n *Network) deleteInstanceGroup(c *NetworkConfig) error {
+	op, err := n.s.InstanceGroups.Delete(n.project, n.zone, c.Name(n.instance)).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func (n *Network) deleteBackendService(c *NetworkConfig) error {
+	var op *compute.Operation
+	var err error
+	if c.Scheme == SchemeHTTPS {
+		op, err = n.s.BackendServices.Delete(n.project, c.Name(n.instance)).Do()
+	} else {
+		op, err = n.s.RegionBackendServices.Delete(n.project, n.region, c.Name(n.instance)).Do()
+	}
+
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func (n *Network) deleteBackendHealthCheck(c *NetworkConfig) error {
+	var op *compute.Operation
+	var err error
+	if c.externalBackend() {
+		op, err = n.s.RegionHealthChecks.Delete(n.project, n.region, c.Name(n.instance)).Do()
+	} else {
+		op, err = n.s.HealthChecks.Delete(n.project, c.Name(n.instance)).Do()
+	}
+
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
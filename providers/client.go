@@ -49,14 +49,20 @@ func (c *Client) loadRegion() error {
 }
 
 func (c *Client) WaitDone(op *compute.Operation) error {
+	// the operations on a shared VPC run at the host project
+	project := c.project
+	if p := projectFromURL(op.SelfLink); p != "" {
+		project = p
+	}
+
 	var doer func(...googleapi.CallOption) (*compute.Operation, error)
 	switch {
 	case op.Region != "":
-		doer = c.s.RegionOperations.Get(c.project, c.region, op.Name).Do
+		doer = c.s.RegionOperations.Get(project, c.region, op.Name).Do
 	case op.Zone != "":
-		doer = c.s.ZoneOperations.Get(c.project, c.zone, op.Name).Do
+		doer = c.s.ZoneOperations.Get(project, c.zone, op.Name).Do
 	default:
-		doer = c.s.GlobalOperations.Get(c.project, op.Name).Do
+		doer = c.s.GlobalOperations.Get(project, op.Name).Do
 	}
 
 	start := time.Now()
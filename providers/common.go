@@ -1,6 +1,12 @@
 package providers
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/googleapi"
+)
 
 func contains(haystack []string, needle string) bool {
 	for _, e := range haystack {
@@ -12,6 +18,50 @@ func contains(haystack []string, needle string) bool {
 	return false
 }
 
+func containsPort(haystack []docker.Port, needle docker.Port) bool {
+	for _, e := range haystack {
+		if e == needle {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsProto(ports []docker.Port, proto string) bool {
+	for _, p := range ports {
+		if p.Proto() == proto {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == 404
+}
+
+// isConflict returns true when a fingerprint doesn't match.
+func isConflict(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == 412 || apiErr.Code == 409)
+}
+
+// projectFromURL returns the project of a resource URL, empty if the URL
+// doesn't include the project.
+func projectFromURL(url string) string {
+	parts := strings.Split(url, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
+
 func DiskURL(project, zone, disks string) string {
 	return fmt.Sprintf(
 		"https://www.googleapis.com/compute/v1/projects/%s/zones/%s/disks/%s",
@@ -43,3 +93,73 @@ func DiskTypeURL(project, zone, diskType string) string {
 		project, zone, diskType,
 	)
 }
+
+func HttpHealthCheckURL(project, healthCheck string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/httpHealthChecks/%s",
+		project, healthCheck,
+	)
+}
+
+func HealthCheckURL(project, healthCheck string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/healthChecks/%s",
+		project, healthCheck,
+	)
+}
+
+func RegionHealthCheckURL(project, region, healthCheck string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/regions/%s/healthChecks/%s",
+		project, region, healthCheck,
+	)
+}
+
+func InstanceGroupURL(project, zone, instanceGroup string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instanceGroups/%s",
+		project, zone, instanceGroup,
+	)
+}
+
+func RegionBackendServiceURL(project, region, backendService string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/regions/%s/backendServices/%s",
+		project, region, backendService,
+	)
+}
+
+func BackendServiceURL(project, backendService string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/backendServices/%s",
+		project, backendService,
+	)
+}
+
+func UrlMapURL(project, urlMap string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/urlMaps/%s",
+		project, urlMap,
+	)
+}
+
+func SslCertificateURL(project, certificate string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/sslCertificates/%s",
+		project, certificate,
+	)
+}
+
+func SecurityPolicyURL(project, policy string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/securityPolicies/%s",
+		project, policy,
+	)
+}
+
+func TargetHttpsProxyURL(project, proxy string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/targetHttpsProxies/%s",
+		project, proxy,
+	)
+}
@@ -55,3 +55,10 @@ func (s *BaseSuite) initEnviroment(c *C) {
 func (s *BaseSuite) getRandomName() string {
 	return time.Now().Format("20060102150405000000")
 }
+
+func (s *CommonSuite) TestProjectFromURL(c *C) {
+	c.Assert(projectFromURL("https://www.googleapis.com/compute/v1/projects/foo/global/networks/bar"), Equals, "foo")
+	c.Assert(projectFromURL("projects/foo/global/networks/bar"), Equals, "foo")
+	c.Assert(projectFromURL("global/networks/bar"), Equals, "")
+	c.Assert(projectFromURL("bar"), Equals, "")
+}
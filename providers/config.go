@@ -3,17 +3,34 @@ package providers
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/fsouza/go-dockerclient"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
 )
 
 var (
-	NetworkBaseName        = "docker-network-%s-%s"
+	NetworkPrefix          = "docker-network-"
+	DescriptionPrefix      = "gce-docker:"
+	NetworkBaseName        = NetworkPrefix + "%s-%s"
 	DiskDeviceNameBaseName = "docker-volume-%s"
 	DiskDevBasePath        = "/dev/disk/by-id/google-%s"
+	DiskRAIDBasePath       = "/dev/md/%s"
+	LocalSSDType           = "local-ssd"
+	StripeBaseName         = "%s-%d"
+
+	LabelVolume    = "gce-docker-volume"
+	LabelStripes   = "gce-docker-stripes"
+	LabelEphemeral = "gce-docker-ephemeral"
+	LabelManaged   = "gce-docker-managed"
+	LabelRelease   = "gce-docker-release"
 )
 
 type DiskConfig struct {
@@ -22,16 +39,76 @@ type DiskConfig struct {
 	SizeGb         int64
 	SourceSnapshot string
 	SourceImage    string
+	// Stripes is the number of disks combined in a RAID0 array, SizeGb is
+	// split evenly between them.
+	Stripes int64
+	// Ephemeral volumes are deleted after being unmounted by the last container.
+	Ephemeral bool
+	// Volume is the name of the volume when the disk is a stripe of it.
+	Volume string
+	Labels map[string]string
+	// Device is the block device backing the volume when it is not derived
+	// from the device name, as happens with the local SSDs.
+	Device string
 }
 
 func (c *DiskConfig) Disk(project, zone string) *compute.Disk {
+	labels := map[string]string{LabelVolume: c.VolumeName()}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+
+	if c.Ephemeral {
+		labels[LabelEphemeral] = "true"
+	}
+
 	return &compute.Disk{
 		Name:           c.Name,
 		Type:           DiskTypeURL(project, zone, c.Type),
 		SizeGb:         c.SizeGb,
 		SourceSnapshot: c.SourceSnapshot,
 		SourceImage:    c.SourceImage,
+		Labels:         labels,
+	}
+}
+
+func (c *DiskConfig) VolumeName() string {
+	if c.Volume != "" {
+		return c.Volume
+	}
+
+	return c.Name
+}
+
+func (c *DiskConfig) IsStriped() bool {
+	return c.Stripes > 1
+}
+
+// StripeConfigs returns the config of every disk of a striped volume.
+func (c *DiskConfig) StripeConfigs() []*DiskConfig {
+	size := c.SizeGb / c.Stripes
+	if c.SizeGb%c.Stripes != 0 {
+		size++
+	}
+
+	var configs []*DiskConfig
+	for i := int64(0); i < c.Stripes; i++ {
+		labels := map[string]string{LabelStripes: strconv.FormatInt(c.Stripes, 10)}
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+
+		configs = append(configs, &DiskConfig{
+			Name:      fmt.Sprintf(StripeBaseName, c.Name, i),
+			Type:      c.Type,
+			SizeGb:    size,
+			Volume:    c.Name,
+			Ephemeral: c.Ephemeral,
+			Labels:    labels,
+		})
 	}
+
+	return configs
 }
 
 func (c *DiskConfig) DeviceName() string {
@@ -39,9 +116,21 @@ func (c *DiskConfig) DeviceName() string {
 }
 
 func (c *DiskConfig) Dev() string {
+	if c.Device != "" {
+		return c.Device
+	}
+
+	if c.IsStriped() {
+		return fmt.Sprintf(DiskRAIDBasePath, c.DeviceName())
+	}
+
 	return fmt.Sprintf(DiskDevBasePath, c.DeviceName())
 }
 
+func (c *DiskConfig) IsLocalSSD() bool {
+	return c.Type == LocalSSDType
+}
+
 func (c *DiskConfig) MountPoint(root string) string {
 	return filepath.Join(root, c.Name)
 }
@@ -55,39 +144,263 @@ func (c *DiskConfig) Validate() error {
 		return fmt.Errorf("invalid dick config, source snapshot and source image can't be presents at the same time.")
 	}
 
+	if c.IsLocalSSD() && (c.SizeGb != 0 || c.SourceSnapshot != "" || c.SourceImage != "") {
+		return fmt.Errorf("invalid disk config, local-ssd disks can't have size, source snapshot or source image")
+	}
+
+	if c.IsLocalSSD() && c.Ephemeral {
+		return fmt.Errorf("invalid disk config, local-ssd disks can't be ephemeral")
+	}
+
+	if c.Stripes < 0 {
+		return fmt.Errorf("invalid disk config, stripes can't be negative")
+	}
+
+	if c.IsStriped() && (c.IsLocalSSD() || c.SourceSnapshot != "" || c.SourceImage != "") {
+		return fmt.Errorf("invalid disk config, striped disks can't be local-ssd or have source snapshot or source image")
+	}
+
 	return nil
 }
 
+// HealthCheckRanges are the source ranges of the health check probes.
+var HealthCheckRanges = []string{"35.191.0.0/16", "209.85.152.0/22", "209.85.204.0/22"}
+
+// BackendServiceRanges are the source ranges of the health check probes and
+// the proxies of the load balancers based on backend services.
+var BackendServiceRanges = []string{"35.191.0.0/16", "130.211.0.0/22"}
+
+// IPv6HealthCheckRanges are the source ranges of the health check probes of
+// the IPv6 external load balancers.
+var IPv6HealthCheckRanges = []string{"2600:1901:8001::/48"}
+
+const (
+	// SchemeExternal is a network load balancer based on a target pool.
+	SchemeExternal = "external"
+	// SchemeInternal is an internal TCP/UDP load balancer based on a regional
+	// backend service.
+	SchemeInternal = "internal"
+	// SchemeHTTPS is a global HTTP(S) load balancer based on a global backend
+	// service, terminating TLS with a Google-managed certificate.
+	SchemeHTTPS = "https"
+)
+
+// IP versions of the forwarding rules. The target pools don't support IPv6,
+// the external load balancers serving IPv6 are based on a regional backend
+// service instead. The internal load balancers are IPv4 only.
+const (
+	IPVersion4    = "IPV4"
+	IPVersion6    = "IPV6"
+	IPVersionDual = "DUAL"
+)
+
+// IPv6RuleBaseName is the name of the IPv6 forwarding rule of a dual stack
+// load balancer.
+var IPv6RuleBaseName = "%s-ipv6"
+
+// HTTPSPortName is the named port of the instance groups serving the https
+// load balancers.
+const HTTPSPortName = "http"
+
+// MaxInternalPorts is the max. number of ports of a forwarding rule pointing to
+// a regional backend service.
+const MaxInternalPorts = 5
+
+// MaxSecurityRuleRanges is the max. number of source ranges of a rule of a
+// Cloud Armor policy, the ranges are split across several rules.
+const MaxSecurityRuleRanges = 10
+
+// Priorities of the rules of the Cloud Armor policies, the allow rules are
+// numbered from SecurityRulePriority, the default rule matches all the traffic.
+const (
+	SecurityRulePriority        int64 = 1000
+	DefaultSecurityRulePriority int64 = 2147483647
+)
+
+var (
+	DefaultFirewallPriority int64 = 1000
+	DenyFirewallBaseName          = "%s-deny"
+	IPv6FirewallBaseName          = "%s-ipv6"
+	DefaultDNSTTL           int64 = 300
+)
+
 type SessionAffinity string
 type NetworkConfig struct {
 	GroupName string
 	Container string
-	Network   string
-	Address   string
-	Ports     []docker.Port
-	Source    struct {
+	// Network is the VPC network of the firewall rules, a name or an URL, by
+	// default the network of the instance.
+	Network string
+	// Project of the instance, set on creation, tells apart the firewall rules
+	// of every service project at the host project of a shared VPC.
+	Project string
+	Address string
+	// Ports are the served ports, or ranges of ports as `30000-30100/udp`.
+	Ports  []docker.Port
+	Source struct {
 		Ranges []string
 		Tags   []string
 	}
 	SessionAffinity SessionAffinity
+	HealthCheck     *HealthCheck
+	// Priority of the firewall rule, nil defaults to DefaultFirewallPriority.
+	Priority *int64
+	// Logging enables the logging of the connections matching the firewall.
+	Logging bool
+	// Deny are the source ranges blocked by a firewall rule evaluated before
+	// the allow rule.
+	Deny []string
+	DNS  *DNS
+	// ReleaseAddress releases on teardown the static address reserved by
+	// gce-docker, by default the address is kept to be reused.
+	ReleaseAddress bool
+	// Scheme of the load balancer, SchemeExternal by default.
+	Scheme string
+	// Subnetwork of the internal forwarding rule, a name or an URL.
+	Subnetwork string
+	// Hosts and Paths routed to the https load balancer, all by default.
+	Hosts []string
+	Paths []string
+	// Certificate is the name of an existing SSL certificate, if empty a
+	// Google-managed certificate is created for the Hosts.
+	Certificate string
+	// DrainSeconds is the time given to the in-flight connections to finish
+	// once the instance is removed from the load balancer.
+	DrainSeconds int64
+	// Index of the label set, when a container has several load balancers.
+	Index string
+	// SecurityPolicy is the name of an existing Cloud Armor policy attached
+	// to the backend service.
+	SecurityPolicy string
+	// SecurityAllow are the source ranges allowed by a Cloud Armor policy
+	// managed by gce-docker, the rest of the traffic is denied.
+	SecurityAllow []string
+	// IPVersion of the forwarding rules, IPVersion4 by default.
+	IPVersion string
+}
+
+// DNSRecordTypes are the types of the records of the IPv4 and IPv6 addresses.
+var DNSRecordTypes = []string{"A", "AAAA"}
+
+// DNS is an A record, along with an AAAA record for the IPv6 addresses,
+// pointing to the addresses of the forwarding rules.
+type DNS struct {
+	Name string
+	// Zone is the name of the managed zone, if empty the zone is the one with
+	// the longest DNS name matching the record.
+	Zone string
+	TTL  int64
+}
+
+// FQDN returns the record name ending with a dot.
+func (d *DNS) FQDN() string {
+	if strings.HasSuffix(d.Name, ".") {
+		return d.Name
+	}
+
+	return d.Name + "."
+}
+
+// ResourceRecordSets returns the A and AAAA records of the given addresses,
+// keyed by type. A type without addresses has no record.
+func (d *DNS) ResourceRecordSets(addresses []string) map[string]*dns.ResourceRecordSet {
+	ttl := d.TTL
+	if ttl == 0 {
+		ttl = DefaultDNSTTL
+	}
+
+	records := make(map[string]*dns.ResourceRecordSet, 0)
+	for _, addr := range addresses {
+		t := "A"
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			t = "AAAA"
+		}
+
+		if records[t] == nil {
+			records[t] = &dns.ResourceRecordSet{Name: d.FQDN(), Type: t, Ttl: ttl}
+		}
+
+		records[t].Rrdatas = append(records[t].Rrdatas, addr)
+	}
+
+	return records
+}
+
+type HealthCheck struct {
+	Path string
+	// Port defaults to the first tcp port.
+	Port int64
+	// Interval is the number of seconds between checks.
+	Interval int64
+	// Threshold is the number of consecutive successes or failures required
+	// to mark an instance as healthy or unhealthy.
+	Threshold int64
 }
 
 func (c *NetworkConfig) TargetPool(project, zone, instance string) *compute.TargetPool {
-	return &compute.TargetPool{
+	pool := &compute.TargetPool{
 		Name:            c.Name(instance),
 		Instances:       []string{InstanceURL(project, zone, instance)},
 		SessionAffinity: string(c.SessionAffinity),
 	}
+
+	if c.HealthCheck != nil {
+		pool.HealthChecks = []string{HttpHealthCheckURL(project, c.Name(instance))}
+	}
+
+	return pool
+}
+
+func (c *NetworkConfig) HttpHealthCheck(instance string) *compute.HttpHealthCheck {
+	if c.HealthCheck == nil {
+		return nil
+	}
+
+	path := c.HealthCheck.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return &compute.HttpHealthCheck{
+		Name:               c.Name(instance),
+		RequestPath:        path,
+		Port:               c.healthCheckPort(),
+		CheckIntervalSec:   c.HealthCheck.Interval,
+		HealthyThreshold:   c.HealthCheck.Threshold,
+		UnhealthyThreshold: c.HealthCheck.Threshold,
+	}
 }
 
+func (c *NetworkConfig) healthCheckPort() int64 {
+	if c.HealthCheck != nil && c.HealthCheck.Port != 0 {
+		return c.HealthCheck.Port
+	}
+
+	return c.servicePort()
+}
+
+// servicePort returns the first tcp port, 80 if there isn't any.
+func (c *NetworkConfig) servicePort() int64 {
+	for _, p := range c.Ports {
+		if p.Proto() == "tcp" {
+			port, _, _ := parsePortRange(p)
+			return int64(port)
+		}
+	}
+
+	return 80
+}
+
+// ForwardingRule returns a forwarding rule for every range of contiguous ports
+// of a protocol, named `<name>-<port>-<proto>` or `<name>-<from>-<to>-<proto>`.
 func (c *NetworkConfig) ForwardingRule(instance, targetPoolURL string) []*compute.ForwardingRule {
 	var rules []*compute.ForwardingRule
-	for _, p := range c.Ports {
+	for _, r := range portRanges(c.Ports) {
 		rules = append(rules, &compute.ForwardingRule{
-			Name:       fmt.Sprintf("%s-%s-%s", c.Name(instance), p.Port(), p.Proto()),
+			Name:       fmt.Sprintf("%s-%s-%s", c.Name(instance), r.String(), r.Proto),
 			IPAddress:  c.Address,
-			IPProtocol: p.Proto(),
-			PortRange:  p.Port(),
+			IPProtocol: r.Proto,
+			PortRange:  r.String(),
 			Target:     targetPoolURL,
 		})
 	}
@@ -96,39 +409,723 @@ func (c *NetworkConfig) ForwardingRule(instance, targetPoolURL string) []*comput
 }
 
 func (c *NetworkConfig) Firewall(instance string) *compute.Firewall {
-	sourceRanges := c.Source.Ranges
-	if len(c.Source.Ranges) == 0 && len(c.Source.Tags) == 0 {
+	sourceRanges, sourceTags := c.Source.Ranges, c.Source.Tags
+	switch {
+	case c.Scheme == SchemeHTTPS:
+		// the traffic comes from the proxies of the load balancer
+		sourceRanges, sourceTags = BackendServiceRanges, nil
+	case c.Scheme == SchemeInternal:
+		// never open to the internet, the sources default to the ranges of
+		// the network when the load balancer is created
+	case len(c.Source.Ranges) == 0 && len(c.Source.Tags) == 0:
 		sourceRanges = []string{"0.0.0.0/0"}
+	case c.externalBackend():
+		// the IPv6 ranges belong to the IPv6 rule
+		sourceRanges = filterRanges(sourceRanges, false)
 	}
 
-	network := c.Network
-	if len(network) == 0 {
-		network = "global/networks/default"
+	if c.HealthCheck != nil || c.usesBackendService() {
+		restricted := len(c.Source.Ranges) != 0 || len(c.Source.Tags) != 0 || c.Scheme == SchemeInternal
+		if restricted && c.Scheme != SchemeHTTPS {
+			sourceRanges = append(append([]string{}, sourceRanges...), c.healthCheckRanges()...)
+		}
 	}
 
 	name := c.Name(instance)
+	return &compute.Firewall{
+		Name:         name,
+		Description:  c.Description(),
+		SourceRanges: sourceRanges,
+		SourceTags:   sourceTags,
+		TargetTags:   []string{name},
+		Network:      c.networkURL(),
+		Allowed:      c.firewallAllowed(),
+		Priority:     c.priority(),
+		LogConfig:    &compute.FirewallLogConfig{Enable: c.Logging},
+	}
+}
+
+// IPv6Firewall returns the rule allowing the IPv6 traffic of an external load
+// balancer, since a rule can't mix IPv4 and IPv6 ranges, nil for the rest. The
+// rule is open to `::/0` unless the sources are restricted, in which case it
+// allows the IPv6 source ranges along with the health check probes.
+func (c *NetworkConfig) IPv6Firewall(instance string) *compute.Firewall {
+	if !c.externalBackend() {
+		return nil
+	}
+
+	sourceRanges := []string{"::/0"}
+	if len(c.Source.Ranges) != 0 || len(c.Source.Tags) != 0 {
+		sourceRanges = append(filterRanges(c.Source.Ranges, true), IPv6HealthCheckRanges...)
+	}
+
+	name := c.Name(instance)
+	return &compute.Firewall{
+		Name:         fmt.Sprintf(IPv6FirewallBaseName, name),
+		Description:  c.Description(),
+		SourceRanges: sourceRanges,
+		TargetTags:   []string{name},
+		Network:      c.networkURL(),
+		Allowed:      c.firewallAllowed(),
+		Priority:     c.priority(),
+		LogConfig:    &compute.FirewallLogConfig{Enable: c.Logging},
+	}
+}
+
+// firewallAllowed returns the ports of the config, along with the port of the
+// health check, if any.
+func (c *NetworkConfig) firewallAllowed() []*compute.FirewallAllowed {
 	var allowed []*compute.FirewallAllowed
-	for _, p := range c.Ports {
+	for _, r := range portRanges(c.Ports) {
 		allowed = append(allowed, &compute.FirewallAllowed{
-			IPProtocol: p.Proto(),
-			Ports:      []string{p.Port()},
+			IPProtocol: r.Proto,
+			Ports:      []string{r.String()},
+		})
+	}
+
+	if c.HealthCheck == nil && !c.usesBackendService() {
+		return allowed
+	}
+
+	port := docker.Port(fmt.Sprintf("%d/tcp", c.healthCheckPort()))
+	if !containsPort(expandPorts(c.Ports), port) {
+		allowed = append(allowed, &compute.FirewallAllowed{
+			IPProtocol: port.Proto(),
+			Ports:      []string{port.Port()},
+		})
+	}
+
+	return allowed
+}
+
+// filterRanges returns the IPv6 ranges, or the IPv4 ones.
+func filterRanges(ranges []string, ipv6 bool) []string {
+	var filtered []string
+	for _, r := range ranges {
+		if strings.Contains(r, ":") == ipv6 {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// DenyFirewall returns the rule blocking the IPv4 Deny source ranges, nil if
+// there isn't any.
+func (c *NetworkConfig) DenyFirewall(instance string) *compute.Firewall {
+	return c.denyFirewall(instance, false)
+}
+
+// IPv6DenyFirewall returns the rule blocking the IPv6 Deny source ranges, nil
+// if there isn't any, named `<name>-deny-ipv6`.
+func (c *NetworkConfig) IPv6DenyFirewall(instance string) *compute.Firewall {
+	return c.denyFirewall(instance, true)
+}
+
+func (c *NetworkConfig) denyFirewall(instance string, ipv6 bool) *compute.Firewall {
+	ranges := filterRanges(c.Deny, ipv6)
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	var denied []*compute.FirewallDenied
+	for _, a := range c.firewallAllowed() {
+		denied = append(denied, &compute.FirewallDenied{
+			IPProtocol: a.IPProtocol,
+			Ports:      a.Ports,
 		})
 	}
 
+	name := c.Name(instance)
 	return &compute.Firewall{
-		Name:         name,
-		SourceRanges: sourceRanges,
-		SourceTags:   c.Source.Tags,
+		Name:         c.denyFirewallName(instance, ipv6),
+		Description:  c.Description(),
+		SourceRanges: ranges,
 		TargetTags:   []string{name},
-		Network:      network,
-		Allowed:      allowed,
+		Network:      c.networkURL(),
+		Denied:       denied,
+		Priority:     c.priority() - 1,
+		LogConfig:    &compute.FirewallLogConfig{Enable: c.Logging},
+	}
+}
+
+func (c *NetworkConfig) denyFirewallName(instance string, ipv6 bool) string {
+	name := fmt.Sprintf(DenyFirewallBaseName, c.Name(instance))
+	if ipv6 {
+		return fmt.Sprintf(IPv6FirewallBaseName, name)
+	}
+
+	return name
+}
+
+// StaticAddress returns the address to be reserved when Address is a name
+// instead of an IP, nil otherwise.
+func (c *NetworkConfig) StaticAddress() *compute.Address {
+	if c.Address == "" || net.ParseIP(c.Address) != nil {
+		return nil
+	}
+
+	addr := &compute.Address{
+		Name:   c.Address,
+		Labels: map[string]string{LabelManaged: "true"},
+	}
+
+	// the collector only releases the unused addresses meant to be released
+	if c.ReleaseAddress {
+		addr.Labels[LabelRelease] = "true"
+	}
+
+	// the static address is the one of the first forwarding rule
+	if c.ipVersions()[0] == IPVersion6 {
+		addr.IpVersion = IPVersion6
+	}
+
+	return addr
+}
+
+// Firewalls returns the firewall rules of the load balancer, the IPv4 rule is
+// left out of an IPv6 only external load balancer.
+func (c *NetworkConfig) Firewalls(instance string) []*compute.Firewall {
+	var rules []*compute.Firewall
+	if !c.externalBackend() || c.IPVersion != IPVersion6 {
+		rules = append(rules, c.Firewall(instance))
+	}
+
+	for _, rule := range []*compute.Firewall{
+		c.IPv6Firewall(instance), c.DenyFirewall(instance), c.IPv6DenyFirewall(instance),
+	} {
+		if rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// FirewallNames returns the names of every firewall rule a load balancer may
+// have, whether or not the config uses them.
+func (c *NetworkConfig) FirewallNames(instance string) []string {
+	name := c.Name(instance)
+	return []string{
+		name,
+		fmt.Sprintf(IPv6FirewallBaseName, name),
+		c.denyFirewallName(instance, false),
+		c.denyFirewallName(instance, true),
+	}
+}
+
+func (c *NetworkConfig) healthCheckRanges() []string {
+	if c.Scheme == SchemeInternal || c.Scheme == SchemeHTTPS {
+		return BackendServiceRanges
+	}
+
+	return HealthCheckRanges
+}
+
+// usesBackendService returns if the load balancer is based on a backend
+// service instead of a target pool.
+func (c *NetworkConfig) usesBackendService() bool {
+	return c.Scheme == SchemeInternal || c.Scheme == SchemeHTTPS || c.externalBackend()
+}
+
+// externalBackend returns if the load balancer is an external one serving
+// IPv6, based on a regional backend service since the target pools are IPv4
+// only.
+func (c *NetworkConfig) externalBackend() bool {
+	if c.Scheme != "" && c.Scheme != SchemeExternal {
+		return false
+	}
+
+	return c.IPVersion == IPVersion6 || c.IPVersion == IPVersionDual
+}
+
+// InstanceGroup returns the unmanaged instance group of the instance, the
+// backend of the load balancers based on backend services.
+func (c *NetworkConfig) InstanceGroup(instance string) *compute.InstanceGroup {
+	group := &compute.InstanceGroup{
+		Name:        c.Name(instance),
+		Description: c.Description(),
+	}
+
+	if c.Scheme == SchemeHTTPS {
+		group.NamedPorts = []*compute.NamedPort{{
+			Name: HTTPSPortName,
+			Port: c.servicePort(),
+		}}
+	}
+
+	return group
+}
+
+// BackendHealthCheck returns a TCP health check, or an HTTP one if any
+// health check option is set.
+func (c *NetworkConfig) BackendHealthCheck(instance string) *compute.HealthCheck {
+	hc := &compute.HealthCheck{Name: c.Name(instance)}
+	if c.HealthCheck == nil {
+		hc.Type = "TCP"
+		hc.TcpHealthCheck = &compute.TCPHealthCheck{Port: c.healthCheckPort()}
+		return hc
+	}
+
+	legacy := c.HttpHealthCheck(instance)
+	hc.Type = "HTTP"
+	hc.HttpHealthCheck = &compute.HTTPHealthCheck{
+		Port:        legacy.Port,
+		RequestPath: legacy.RequestPath,
+	}
+
+	hc.CheckIntervalSec = legacy.CheckIntervalSec
+	hc.HealthyThreshold = legacy.HealthyThreshold
+	hc.UnhealthyThreshold = legacy.UnhealthyThreshold
+	return hc
+}
+
+// BackendService returns the backend service of the load balancer, without
+// backends, the instance groups are added one by one. The service is global
+// for the https scheme and regional otherwise.
+func (c *NetworkConfig) BackendService(project, region, instance string) *compute.BackendService {
+	s := &compute.BackendService{
+		Name:                c.Name(instance),
+		Description:         c.Description(),
+		LoadBalancingScheme: "INTERNAL",
+		Protocol:            c.protocol(),
+		HealthChecks:        []string{c.BackendHealthCheckURL(project, region, instance)},
+		SessionAffinity:     string(c.SessionAffinity),
+	}
+
+	if c.DrainSeconds != 0 {
+		s.ConnectionDraining = &compute.ConnectionDraining{DrainingTimeoutSec: c.DrainSeconds}
+	}
+
+	switch {
+	case c.Scheme == SchemeHTTPS:
+		s.LoadBalancingScheme = "EXTERNAL"
+		s.Protocol = "HTTP"
+		s.PortName = HTTPSPortName
+	case c.externalBackend():
+		s.LoadBalancingScheme = "EXTERNAL"
+	}
+
+	return s
+}
+
+// BackendHealthCheckURL returns the URL of the health check of the backend
+// service, the external load balancers require a regional one.
+func (c *NetworkConfig) BackendHealthCheckURL(project, region, instance string) string {
+	if c.externalBackend() {
+		return RegionHealthCheckURL(project, region, c.Name(instance))
+	}
+
+	return HealthCheckURL(project, c.Name(instance))
+}
+
+// Backend returns the backend of the instance group of the given zone.
+func (c *NetworkConfig) Backend(project, zone, instance string) *compute.Backend {
+	b := &compute.Backend{
+		Group:         InstanceGroupURL(project, zone, c.Name(instance)),
+		BalancingMode: "CONNECTION",
+	}
+
+	if c.Scheme == SchemeHTTPS {
+		b.BalancingMode = "UTILIZATION"
+	}
+
+	return b
+}
+
+// BackendServiceURL returns the URL of the backend service of the scheme.
+func (c *NetworkConfig) BackendServiceURL(project, region, instance string) string {
+	if c.Scheme == SchemeHTTPS {
+		return BackendServiceURL(project, c.Name(instance))
+	}
+
+	return RegionBackendServiceURL(project, region, c.Name(instance))
+}
+
+// SslCertificate returns the Google-managed certificate for the Hosts, nil
+// when an existing certificate is given.
+func (c *NetworkConfig) SslCertificate(instance string) *compute.SslCertificate {
+	if c.Certificate != "" {
+		return nil
+	}
+
+	return &compute.SslCertificate{
+		Name:        c.Name(instance),
+		Description: c.Description(),
+		Type:        "MANAGED",
+		Managed:     &compute.SslCertificateManagedSslCertificate{Domains: c.Hosts},
 	}
 }
 
+// TargetHttpsProxy returns the proxy of the group frontend, serving the
+// certificate of the config.
+func (c *NetworkConfig) TargetHttpsProxy(project, instance string) *compute.TargetHttpsProxy {
+	return &compute.TargetHttpsProxy{
+		Name:            c.FrontendName(instance),
+		Description:     c.Description(),
+		UrlMap:          UrlMapURL(project, c.FrontendName(instance)),
+		SslCertificates: []string{c.SslCertificateURL(project, instance)},
+	}
+}
+
+// SslCertificateURL returns the URL of the given or the managed certificate.
+func (c *NetworkConfig) SslCertificateURL(project, instance string) string {
+	if c.Certificate != "" {
+		return SslCertificateURL(project, c.Certificate)
+	}
+
+	return SslCertificateURL(project, c.Name(instance))
+}
+
+// ManagedSecurityPolicy returns the Cloud Armor policy allowing the
+// SecurityAllow ranges and denying the rest, nil if there isn't any range.
+func (c *NetworkConfig) ManagedSecurityPolicy(instance string) *compute.SecurityPolicy {
+	if len(c.SecurityAllow) == 0 {
+		return nil
+	}
+
+	var rules []*compute.SecurityPolicyRule
+	for i := 0; i < len(c.SecurityAllow); i += MaxSecurityRuleRanges {
+		end := i + MaxSecurityRuleRanges
+		if end > len(c.SecurityAllow) {
+			end = len(c.SecurityAllow)
+		}
+
+		rules = append(rules, securityRule("allow", SecurityRulePriority+int64(len(rules)), c.SecurityAllow[i:end]))
+	}
+
+	rules = append(rules, securityRule("deny(403)", DefaultSecurityRulePriority, []string{"*"}))
+	return &compute.SecurityPolicy{
+		Name:        c.Name(instance),
+		Description: c.Description(),
+		Rules:       rules,
+	}
+}
+
+func securityRule(action string, priority int64, ranges []string) *compute.SecurityPolicyRule {
+	return &compute.SecurityPolicyRule{
+		Action:   action,
+		Priority: priority,
+		Match: &compute.SecurityPolicyRuleMatcher{
+			VersionedExpr: "SRC_IPS_V1",
+			Config:        &compute.SecurityPolicyRuleMatcherConfig{SrcIpRanges: ranges},
+		},
+	}
+}
+
+// SecurityPolicyURL returns the URL of the Cloud Armor policy of the backend
+// service, the given or the managed one, empty if there isn't any.
+func (c *NetworkConfig) SecurityPolicyURL(project, instance string) string {
+	switch {
+	case strings.Contains(c.SecurityPolicy, "/"):
+		return c.SecurityPolicy
+	case c.SecurityPolicy != "":
+		return SecurityPolicyURL(project, c.SecurityPolicy)
+	case len(c.SecurityAllow) != 0:
+		return SecurityPolicyURL(project, c.Name(instance))
+	}
+
+	return ""
+}
+
+// GlobalForwardingRules returns a forwarding rule for every IP version, the
+// static address, if any, is served by the first one. The IPv6 rule of a dual
+// stack load balancer is named `<name>-ipv6`.
+func (c *NetworkConfig) GlobalForwardingRules(project, instance string) []*compute.ForwardingRule {
+	var rules []*compute.ForwardingRule
+	for i, version := range c.ipVersions() {
+		var address string
+		if i == 0 {
+			address = c.Address
+		}
+
+		rules = append(rules, &compute.ForwardingRule{
+			Name:                c.forwardingRuleName(instance, version),
+			Description:         c.Description(),
+			IPAddress:           address,
+			IPProtocol:          "TCP",
+			IpVersion:           version,
+			PortRange:           "443",
+			LoadBalancingScheme: "EXTERNAL",
+			Target:              TargetHttpsProxyURL(project, c.FrontendName(instance)),
+		})
+	}
+
+	return rules
+}
+
+// ExternalForwardingRules returns a forwarding rule for every IP version of
+// an external load balancer serving IPv6, named as the global ones. The IPv6
+// rule gets an ephemeral address from the subnetwork.
+func (c *NetworkConfig) ExternalForwardingRules(instance, region, backendServiceURL string) []*compute.ForwardingRule {
+	var ports []string
+	for _, p := range expandPorts(c.Ports) {
+		ports = append(ports, p.Port())
+	}
+
+	var rules []*compute.ForwardingRule
+	for i, version := range c.ipVersions() {
+		rule := &compute.ForwardingRule{
+			Name:                c.forwardingRuleName(instance, version),
+			Description:         c.Description(),
+			IPProtocol:          c.protocol(),
+			IpVersion:           version,
+			Ports:               ports,
+			LoadBalancingScheme: "EXTERNAL",
+			BackendService:      backendServiceURL,
+		}
+
+		if i == 0 {
+			rule.IPAddress = c.Address
+		}
+
+		if version == IPVersion6 {
+			rule.Subnetwork = c.subnetworkURL(region)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// forwardingRuleName returns the name of the rule of the IP version, the IPv6
+// rule of a dual stack load balancer is named `<name>-ipv6`. The rules of the
+// https scheme belong to the group frontend.
+func (c *NetworkConfig) forwardingRuleName(instance, version string) string {
+	name := c.Name(instance)
+	if c.Scheme == SchemeHTTPS {
+		name = c.FrontendName(instance)
+	}
+
+	if version == IPVersion6 && c.IPVersion == IPVersionDual {
+		return fmt.Sprintf(IPv6RuleBaseName, name)
+	}
+
+	return name
+}
+
+func (c *NetworkConfig) ipVersions() []string {
+	switch c.IPVersion {
+	case IPVersion6:
+		return []string{IPVersion6}
+	case IPVersionDual:
+		return []string{IPVersion4, IPVersion6}
+	}
+
+	return []string{IPVersion4}
+}
+
+// InternalForwardingRule returns the internal forwarding rule serving all the
+// ports of the config.
+func (c *NetworkConfig) InternalForwardingRule(instance, region, backendServiceURL string) *compute.ForwardingRule {
+	var ports []string
+	for _, p := range expandPorts(c.Ports) {
+		ports = append(ports, p.Port())
+	}
+
+	return &compute.ForwardingRule{
+		Name:                c.Name(instance),
+		Description:         c.Description(),
+		IPAddress:           c.Address,
+		IPProtocol:          c.protocol(),
+		Ports:               ports,
+		LoadBalancingScheme: "INTERNAL",
+		BackendService:      backendServiceURL,
+		Network:             c.networkURL(),
+		Subnetwork:          c.subnetworkURL(region),
+	}
+}
+
+// subnetworkURL returns the subnetwork of the config as a partial URL when
+// it's given by name, at the host project of a shared VPC.
+func (c *NetworkConfig) subnetworkURL(region string) string {
+	subnetwork := c.Subnetwork
+	if subnetwork == "" || strings.Contains(subnetwork, "/") {
+		return subnetwork
+	}
+
+	subnetwork = fmt.Sprintf("regions/%s/subnetworks/%s", region, subnetwork)
+
+	// the subnetworks of a shared VPC belong to the host project
+	if project := projectFromURL(c.networkURL()); project != "" {
+		subnetwork = fmt.Sprintf("projects/%s/%s", project, subnetwork)
+	}
+
+	return subnetwork
+}
+
+// portRange is a range of contiguous ports of a protocol.
+type portRange struct {
+	Proto    string
+	From, To int
+}
+
+// String returns the range as `30000-30100`, or the port alone as `80`.
+func (r *portRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// portRanges merges the contiguous ports, and ranges, of every protocol, the
+// ranges are sorted by protocol and port.
+func portRanges(ports []docker.Port) []*portRange {
+	byProto := make(map[string][]*portRange, 0)
+	for _, p := range ports {
+		from, to, err := parsePortRange(p)
+		if err != nil {
+			continue
+		}
+
+		byProto[p.Proto()] = append(byProto[p.Proto()], &portRange{
+			Proto: p.Proto(), From: from, To: to,
+		})
+	}
+
+	var protos []string
+	for proto := range byProto {
+		protos = append(protos, proto)
+	}
+
+	sort.Strings(protos)
+
+	var ranges []*portRange
+	for _, proto := range protos {
+		sort.Sort(portRangesByFrom(byProto[proto]))
+
+		var last *portRange
+		for _, r := range byProto[proto] {
+			if last != nil && r.From <= last.To+1 {
+				if r.To > last.To {
+					last.To = r.To
+				}
+
+				continue
+			}
+
+			last = &portRange{Proto: r.Proto, From: r.From, To: r.To}
+			ranges = append(ranges, last)
+		}
+	}
+
+	return ranges
+}
+
+type portRangesByFrom []*portRange
+
+func (r portRangesByFrom) Len() int           { return len(r) }
+func (r portRangesByFrom) Less(i, j int) bool { return r[i].From < r[j].From }
+func (r portRangesByFrom) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// parsePortRange returns the first and the last port of a port, or a range
+// of ports as `30000-30100/udp`.
+func parsePortRange(p docker.Port) (from, to int, err error) {
+	parts := strings.SplitN(p.Port(), "-", 2)
+	from, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", p)
+	}
+
+	to = from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+		if err != nil || to < from {
+			return 0, 0, fmt.Errorf("invalid port range %q", p)
+		}
+	}
+
+	return from, to, nil
+}
+
+// CompactPorts merges the contiguous ports of every protocol into ranges, as
+// `30000-30100/udp`, keeping short the configs stored at the descriptions.
+func CompactPorts(ports []docker.Port) []docker.Port {
+	var compact []docker.Port
+	for _, r := range portRanges(ports) {
+		compact = append(compact, docker.Port(r.String()+"/"+r.Proto))
+	}
+
+	return compact
+}
+
+// expandPorts returns every port of the ranges, in order.
+func expandPorts(ports []docker.Port) []docker.Port {
+	var expanded []docker.Port
+	for _, p := range ports {
+		from, to, err := parsePortRange(p)
+		if err != nil || from == to {
+			expanded = append(expanded, p)
+			continue
+		}
+
+		for port := from; port <= to; port++ {
+			expanded = append(expanded, docker.Port(fmt.Sprintf("%d/%s", port, p.Proto())))
+		}
+	}
+
+	return expanded
+}
+
+// networkURL returns the network of the config as an URL, a partial one when
+// the network is given by name, the default network if empty.
+func (c *NetworkConfig) networkURL() string {
+	switch {
+	case c.Network == "":
+		return "global/networks/default"
+	case !strings.Contains(c.Network, "/"):
+		return "global/networks/" + c.Network
+	}
+
+	return c.Network
+}
+
+func (c *NetworkConfig) protocol() string {
+	if len(c.Ports) == 0 {
+		return "TCP"
+	}
+
+	return strings.ToUpper(c.Ports[0].Proto())
+}
+
+func (c *NetworkConfig) priority() int64 {
+	if c.Priority != nil {
+		return *c.Priority
+	}
+
+	return DefaultFirewallPriority
+}
+
+// Description returns the config encoded to be stored as description of the
+// resources, allowing to tear down the load balancer without the container.
+func (c *NetworkConfig) Description() string {
+	content, _ := json.Marshal(c)
+	return DescriptionPrefix + string(content)
+}
+
+func DecodeNetworkConfig(description string) (*NetworkConfig, error) {
+	if !strings.HasPrefix(description, DescriptionPrefix) {
+		return nil, fmt.Errorf("invalid description, not created by gce-docker")
+	}
+
+	c := &NetworkConfig{}
+	err := json.Unmarshal([]byte(strings.TrimPrefix(description, DescriptionPrefix)), c)
+	return c, err
+}
+
 func (c *NetworkConfig) Name(instance string) string {
 	return fmt.Sprintf(NetworkBaseName, c.Group(instance), c.ID(instance))
 }
 
+// FrontendName returns the name of the URL map, target proxy and forwarding
+// rules shared by the https load balancers of the group.
+func (c *NetworkConfig) FrontendName(instance string) string {
+	hash := md5.Sum([]byte(c.Group(instance)))
+	return fmt.Sprintf(NetworkBaseName, c.Group(instance), hex.EncodeToString(hash[:])[:8])
+}
+
 func (c *NetworkConfig) Group(instance string) string {
 	if c.GroupName != "" {
 		return c.GroupName
@@ -141,10 +1138,16 @@ func (c *NetworkConfig) ID(instance string) string {
 	var unique string
 	unique += c.Group(instance)
 	unique += c.Address
-	for _, p := range c.Ports {
+	unique += c.Index
+	for _, p := range expandPorts(c.Ports) {
 		unique += string(p)
 	}
 
+	// the resources of an IPv4 load balancer aren't reused by an IPv6 one
+	if c.IPVersion != "" && c.IPVersion != IPVersion4 {
+		unique += c.IPVersion
+	}
+
 	hash := md5.Sum([]byte(unique))
 	return hex.EncodeToString(hash[:])[:8]
 }
@@ -158,5 +1161,108 @@ func (c *NetworkConfig) Validate() error {
 		return fmt.Errorf("invalid network config, ports field cannot be empty")
 	}
 
+	for _, p := range c.Ports {
+		if _, _, err := parsePortRange(p); err != nil {
+			return fmt.Errorf("invalid network config, %s", err)
+		}
+	}
+
+	if p := c.priority(); p < 0 || p > 65535 {
+		return fmt.Errorf("invalid network config, priority must be between 0 and 65535")
+	}
+
+	// the deny rule takes precedence over the allow one, one priority below
+	if len(c.Deny) != 0 && c.priority() == 0 {
+		return fmt.Errorf("invalid network config, priority must be above 0 with deny ranges")
+	}
+
+	if (c.SecurityPolicy != "" || len(c.SecurityAllow) != 0) && c.Scheme != SchemeHTTPS {
+		return fmt.Errorf("invalid network config, security policies require the %s scheme", SchemeHTTPS)
+	}
+
+	switch c.IPVersion {
+	case "", IPVersion4:
+	case IPVersion6, IPVersionDual:
+		if c.Scheme == SchemeInternal {
+			return fmt.Errorf("invalid network config, internal load balancers don't support IPv6")
+		}
+	default:
+		return fmt.Errorf("invalid network config, unknown IP version %q", c.IPVersion)
+	}
+
+	switch c.Scheme {
+	case "", SchemeExternal:
+		if c.externalBackend() {
+			return c.validateExternalBackend()
+		}
+
+		if c.Subnetwork != "" {
+			return fmt.Errorf("invalid network config, subnetwork requires the %s scheme or IPv6", SchemeInternal)
+		}
+	case SchemeInternal:
+		return c.validateRulePorts(SchemeInternal)
+	case SchemeHTTPS:
+		return c.validateHTTPS()
+	default:
+		return fmt.Errorf("invalid network config, unknown scheme %q", c.Scheme)
+	}
+
+	return nil
+}
+
+// validateRulePorts validates the ports of a forwarding rule pointing to a
+// regional backend service.
+func (c *NetworkConfig) validateRulePorts(scheme string) error {
+	if len(expandPorts(c.Ports)) > MaxInternalPorts {
+		return fmt.Errorf("invalid network config, %s load balancers support up to %d ports", scheme, MaxInternalPorts)
+	}
+
+	for _, p := range c.Ports {
+		if p.Proto() != c.Ports[0].Proto() {
+			return fmt.Errorf("invalid network config, %s load balancers support a single protocol", scheme)
+		}
+	}
+
+	return nil
+}
+
+func (c *NetworkConfig) validateExternalBackend() error {
+	// the external IPv6 addresses are allocated from the subnetwork
+	if c.ipVersions()[0] == IPVersion6 && c.StaticAddress() != nil {
+		return fmt.Errorf("invalid network config, static IPv6 addresses require the %s scheme", SchemeHTTPS)
+	}
+
+	return c.validateRulePorts(SchemeExternal + " IPv6")
+}
+
+func (c *NetworkConfig) validateHTTPS() error {
+	if c.Subnetwork != "" {
+		return fmt.Errorf("invalid network config, subnetwork requires the %s scheme", SchemeInternal)
+	}
+
+	if !containsProto(c.Ports, "tcp") {
+		return fmt.Errorf("invalid network config, https load balancers require a tcp port")
+	}
+
+	if c.Certificate == "" && len(c.Hosts) == 0 {
+		return fmt.Errorf("invalid network config, hosts are required by the managed certificate")
+	}
+
+	for _, p := range c.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("invalid network config, path %q must start with /", p)
+		}
+	}
+
+	if c.SecurityPolicy != "" && len(c.SecurityAllow) != 0 {
+		return fmt.Errorf("invalid network config, security policy and allowed ranges are exclusive")
+	}
+
+	for _, r := range c.SecurityAllow {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("invalid network config, allowed range %q: %s", r, err)
+		}
+	}
+
 	return nil
 }
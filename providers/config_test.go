@@ -1,7 +1,10 @@
 package providers
 
 import (
+	"fmt"
+
 	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/compute/v1"
 	. "gopkg.in/check.v1"
 )
 
@@ -24,6 +27,32 @@ func (s *ConfigSuite) TestNetworkConfigDisk(c *C) {
 	c.Assert(d.SizeGb, Equals, int64(42))
 	c.Assert(d.SourceSnapshot, Equals, "bar")
 	c.Assert(d.SourceImage, Equals, "baz")
+	c.Assert(d.Labels, DeepEquals, map[string]string{"gce-docker-volume": "foo"})
+}
+
+func (s *ConfigSuite) TestDiskConfigStripeConfigs(c *C) {
+	config := &DiskConfig{Name: "foo", Type: "qux", SizeGb: 100, Stripes: 3}
+	c.Assert(config.Dev(), Equals, "/dev/md/docker-volume-foo")
+
+	stripes := config.StripeConfigs()
+	c.Assert(stripes, HasLen, 3)
+	c.Assert(stripes[0].Name, Equals, "foo-0")
+	c.Assert(stripes[2].Name, Equals, "foo-2")
+	c.Assert(stripes[2].SizeGb, Equals, int64(34))
+	c.Assert(stripes[2].Dev(), Equals, "/dev/disk/by-id/google-docker-volume-foo-2")
+
+	d := stripes[1].Disk("project", "foo-c")
+	c.Assert(d.Type, Equals, "https://www.googleapis.com/compute/v1/projects/project/zones/foo-c/diskTypes/qux")
+	c.Assert(d.Labels, DeepEquals, map[string]string{
+		"gce-docker-volume":  "foo",
+		"gce-docker-stripes": "3",
+	})
+}
+
+func (s *ConfigSuite) TestDiskConfigEphemeral(c *C) {
+	config := &DiskConfig{Name: "foo", Ephemeral: true, Stripes: 2}
+	c.Assert(config.Disk("project", "foo-c").Labels[LabelEphemeral], Equals, "true")
+	c.Assert(config.StripeConfigs()[1].Disk("project", "foo-c").Labels[LabelEphemeral], Equals, "true")
 }
 
 func (s *ConfigSuite) TestNetworkConfigValidate(c *C) {
@@ -38,6 +67,10 @@ func (s *ConfigSuite) TestNetworkConfigValidate(c *C) {
 	config = &DiskConfig{Name: "foo", SourceSnapshot: "foo", SourceImage: "foo"}
 	err = config.Validate()
 	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", Stripes: 2, SourceSnapshot: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
 }
 
 func (s *ConfigSuite) TestNetworkConfigDeviceName(c *C) {
@@ -90,3 +123,529 @@ func (s *ConfigSuite) TestNetworkConfigTargetPool(c *C) {
 	c.Assert(tp.Instances[0], Equals, "https://www.googleapis.com/compute/v1/projects/bar/zones/baz/instances/foo")
 	c.Assert(tp.SessionAffinity, Equals, "qux")
 }
+
+func (s *ConfigSuite) TestNetworkConfigForwardingRulePortRanges(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports: []docker.Port{
+			docker.Port("30001/udp"), docker.Port("80/tcp"), docker.Port("30000/udp"),
+			docker.Port("30002/udp"), docker.Port("443/tcp"),
+		},
+	}
+
+	rules := config.ForwardingRule("foo", "pool")
+	c.Assert(rules, HasLen, 3)
+	c.Assert(rules[0].Name, Equals, config.Name("foo")+"-80-tcp")
+	c.Assert(rules[0].PortRange, Equals, "80")
+	c.Assert(rules[1].Name, Equals, config.Name("foo")+"-443-tcp")
+	c.Assert(rules[2].Name, Equals, config.Name("foo")+"-30000-30002-udp")
+	c.Assert(rules[2].IPProtocol, Equals, "udp")
+	c.Assert(rules[2].PortRange, Equals, "30000-30002")
+
+	fw := config.Firewall("foo")
+	c.Assert(fw.Allowed, HasLen, 3)
+	c.Assert(fw.Allowed[2].Ports, DeepEquals, []string{"30000-30002"})
+
+	config.Ports = CompactPorts(config.Ports)
+	c.Assert(config.Ports, DeepEquals, []docker.Port{"80/tcp", "443/tcp", "30000-30002/udp"})
+	c.Assert(config.ForwardingRule("foo", "pool"), HasLen, 3)
+	c.Assert(config.Validate(), IsNil)
+
+	config.Ports = []docker.Port{"30000-30002/udp", "30001-30010/udp", "30011/udp"}
+	c.Assert(CompactPorts(config.Ports), DeepEquals, []docker.Port{"30000-30011/udp"})
+
+	// the names of the load balancers don't change with the compacted ports
+	expanded := &NetworkConfig{Container: "bar", Ports: []docker.Port{"80/tcp", "81/tcp"}}
+	compact := &NetworkConfig{Container: "bar", Ports: []docker.Port{"80-81/tcp"}}
+	c.Assert(compact.Name("foo"), Equals, expanded.Name("foo"))
+
+	config.Ports = []docker.Port{"30002-30000/udp"}
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigHttpHealthCheck(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports:     []docker.Port{docker.Port("53/udp"), docker.Port("8080/tcp")},
+	}
+
+	c.Assert(config.HttpHealthCheck("foo"), IsNil)
+	c.Assert(config.TargetPool("bar", "baz", "foo").HealthChecks, HasLen, 0)
+
+	config.HealthCheck = &HealthCheck{Interval: 5, Threshold: 3}
+	hc := config.HttpHealthCheck("foo")
+	c.Assert(hc.Name, Equals, config.Name("foo"))
+	c.Assert(hc.RequestPath, Equals, "/")
+	c.Assert(hc.Port, Equals, int64(8080))
+	c.Assert(hc.CheckIntervalSec, Equals, int64(5))
+	c.Assert(hc.HealthyThreshold, Equals, int64(3))
+	c.Assert(hc.UnhealthyThreshold, Equals, int64(3))
+
+	tp := config.TargetPool("bar", "baz", "foo")
+	c.Assert(tp.HealthChecks, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/bar/global/httpHealthChecks/" + config.Name("foo"),
+	})
+}
+
+func (s *ConfigSuite) TestNetworkConfigFirewallHealthCheck(c *C) {
+	config := &NetworkConfig{
+		Container:   "bar",
+		Ports:       []docker.Port{docker.Port("80/tcp")},
+		HealthCheck: &HealthCheck{Port: 8080},
+	}
+	config.Source.Ranges = []string{"10.0.0.0/8"}
+
+	fw := config.Firewall("foo")
+	c.Assert(fw.SourceRanges, DeepEquals, append([]string{"10.0.0.0/8"}, HealthCheckRanges...))
+	c.Assert(fw.Allowed, HasLen, 2)
+	c.Assert(fw.Allowed[1].Ports, DeepEquals, []string{"8080"})
+	c.Assert(config.Source.Ranges, HasLen, 1)
+}
+
+func (s *ConfigSuite) TestNetworkConfigDescription(c *C) {
+	config := &NetworkConfig{
+		GroupName:   "foo",
+		Container:   "bar",
+		Ports:       []docker.Port{docker.Port("80/tcp")},
+		HealthCheck: &HealthCheck{Path: "/health"},
+	}
+	config.Source.Ranges = []string{"10.0.0.0/8"}
+
+	c.Assert(config.Firewall("qux").Description, Equals, config.Description())
+
+	decoded, err := DecodeNetworkConfig(config.Description())
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, config)
+	c.Assert(decoded.Name("qux"), Equals, config.Name("qux"))
+
+	_, err = DecodeNetworkConfig("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigFirewalls(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports:     []docker.Port{docker.Port("80/tcp"), docker.Port("53/udp")},
+	}
+
+	rules := config.Firewalls("foo")
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].Priority, Equals, int64(1000))
+	c.Assert(rules[0].LogConfig.Enable, Equals, false)
+
+	priority := int64(500)
+	config.Priority = &priority
+	config.Logging = true
+	config.Deny = []string{"192.0.2.0/24"}
+
+	rules = config.Firewalls("foo")
+	c.Assert(rules, HasLen, 2)
+	c.Assert(rules[0].Priority, Equals, int64(500))
+	c.Assert(rules[0].LogConfig.Enable, Equals, true)
+
+	deny := rules[1]
+	c.Assert(deny.Name, Equals, config.Name("foo")+"-deny")
+	c.Assert(deny.Priority, Equals, int64(499))
+	c.Assert(deny.SourceRanges, DeepEquals, []string{"192.0.2.0/24"})
+	c.Assert(deny.TargetTags, DeepEquals, []string{config.Name("foo")})
+	c.Assert(deny.Allowed, HasLen, 0)
+	c.Assert(deny.Denied, HasLen, 2)
+	c.Assert(deny.Denied[1].IPProtocol, Equals, "udp")
+	c.Assert(deny.Denied[1].Ports, DeepEquals, []string{"53"})
+
+	config.Deny = []string{"192.0.2.0/24", "2001:db8::/32"}
+	rules = config.Firewalls("foo")
+	c.Assert(rules, HasLen, 3)
+	c.Assert(rules[1].SourceRanges, DeepEquals, []string{"192.0.2.0/24"})
+	c.Assert(rules[2].Name, Equals, config.Name("foo")+"-deny-ipv6")
+	c.Assert(rules[2].SourceRanges, DeepEquals, []string{"2001:db8::/32"})
+	c.Assert(rules[2].Priority, Equals, int64(499))
+	c.Assert(config.FirewallNames("foo"), DeepEquals, []string{
+		config.Name("foo"), config.Name("foo") + "-ipv6", config.Name("foo") + "-deny", config.Name("foo") + "-deny-ipv6",
+	})
+
+	config.Deny = []string{"2001:db8::/32"}
+	c.Assert(config.DenyFirewall("foo"), IsNil)
+	c.Assert(config.IPv6DenyFirewall("foo"), NotNil)
+
+	config.Deny = []string{"192.0.2.0/24"}
+	priority = 0
+	c.Assert(config.Firewall("foo").Priority, Equals, int64(0))
+	c.Assert(config.Validate(), NotNil)
+
+	config.Deny = nil
+	c.Assert(config.Validate(), IsNil)
+
+	priority = 65536
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestDNSResourceRecordSets(c *C) {
+	d := &DNS{Name: "www.example.com"}
+	c.Assert(d.FQDN(), Equals, "www.example.com.")
+
+	records := d.ResourceRecordSets([]string{"192.0.2.1"})
+	c.Assert(records, HasLen, 1)
+	rrs := records["A"]
+	c.Assert(rrs.Name, Equals, "www.example.com.")
+	c.Assert(rrs.Type, Equals, "A")
+	c.Assert(rrs.Ttl, Equals, int64(300))
+	c.Assert(rrs.Rrdatas, DeepEquals, []string{"192.0.2.1"})
+
+	d = &DNS{Name: "www.example.com.", TTL: 60}
+	c.Assert(d.FQDN(), Equals, "www.example.com.")
+
+	records = d.ResourceRecordSets([]string{"192.0.2.1", "2001:db8::1"})
+	c.Assert(records, HasLen, 2)
+	c.Assert(records["A"].Ttl, Equals, int64(60))
+	c.Assert(records["AAAA"].Type, Equals, "AAAA")
+	c.Assert(records["AAAA"].Rrdatas, DeepEquals, []string{"2001:db8::1"})
+	c.Assert(d.ResourceRecordSets(nil), HasLen, 0)
+}
+
+func (s *ConfigSuite) TestNetworkConfigStaticAddress(c *C) {
+	config := &NetworkConfig{Container: "bar"}
+	c.Assert(config.StaticAddress(), IsNil)
+
+	config.Address = "104.197.200.230"
+	c.Assert(config.StaticAddress(), IsNil)
+
+	config.Address = "web"
+	addr := config.StaticAddress()
+	c.Assert(addr.Name, Equals, "web")
+	c.Assert(addr.Labels, DeepEquals, map[string]string{"gce-docker-managed": "true"})
+}
+
+func (s *ConfigSuite) TestNetworkConfigInternal(c *C) {
+	config := &NetworkConfig{
+		Container:  "bar",
+		Scheme:     SchemeInternal,
+		Subnetwork: "backend",
+		Ports:      []docker.Port{docker.Port("80/tcp"), docker.Port("443/tcp")},
+	}
+	c.Assert(config.Validate(), IsNil)
+
+	hc := config.BackendHealthCheck("foo")
+	c.Assert(hc.Type, Equals, "TCP")
+	c.Assert(hc.TcpHealthCheck.Port, Equals, int64(80))
+
+	bs := config.BackendService("qux", "us-central1", "foo")
+	c.Assert(bs.LoadBalancingScheme, Equals, "INTERNAL")
+	c.Assert(bs.Protocol, Equals, "TCP")
+	c.Assert(bs.HealthChecks, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/qux/global/healthChecks/" + config.Name("foo"),
+	})
+
+	rule := config.InternalForwardingRule("foo", "us-central1", "service")
+	c.Assert(rule.Name, Equals, config.Name("foo"))
+	c.Assert(rule.Ports, DeepEquals, []string{"80", "443"})
+	c.Assert(rule.IPProtocol, Equals, "TCP")
+	c.Assert(rule.BackendService, Equals, "service")
+	c.Assert(rule.Subnetwork, Equals, "regions/us-central1/subnetworks/backend")
+
+	fw := config.Firewall("foo")
+	c.Assert(fw.SourceRanges, DeepEquals, BackendServiceRanges)
+
+	config.Source.Ranges = []string{"10.128.0.0/9"}
+	fw = config.Firewall("foo")
+	c.Assert(fw.SourceRanges, DeepEquals, append([]string{"10.128.0.0/9"}, BackendServiceRanges...))
+	config.Source.Ranges = nil
+
+	config.Ports = append(config.Ports, docker.Port("53/udp"))
+	c.Assert(config.Validate(), NotNil)
+
+	config.Scheme = SchemeExternal
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigNetwork(c *C) {
+	config := &NetworkConfig{
+		Container:  "bar",
+		Scheme:     SchemeInternal,
+		Subnetwork: "backend",
+		Ports:      []docker.Port{docker.Port("80/tcp")},
+	}
+
+	c.Assert(config.Firewall("foo").Network, Equals, "global/networks/default")
+
+	config.Network = "vpc"
+	c.Assert(config.Firewall("foo").Network, Equals, "global/networks/vpc")
+	c.Assert(config.InternalForwardingRule("foo", "us-central1", "service").Subnetwork,
+		Equals, "regions/us-central1/subnetworks/backend")
+
+	config.Network = "https://www.googleapis.com/compute/v1/projects/host/global/networks/vpc"
+	c.Assert(config.Firewall("foo").Network, Equals, config.Network)
+
+	rule := config.InternalForwardingRule("foo", "us-central1", "service")
+	c.Assert(rule.Network, Equals, config.Network)
+	c.Assert(rule.Subnetwork, Equals, "projects/host/regions/us-central1/subnetworks/backend")
+}
+
+func (s *ConfigSuite) TestNetworkConfigHTTPS(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Paths:     []string{"/api/*"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+	}
+	config.Source.Ranges = []string{"10.0.0.0/8"}
+	c.Assert(config.Validate(), IsNil)
+
+	name := config.Name("foo")
+	group := config.InstanceGroup("foo")
+	c.Assert(group.NamedPorts, HasLen, 1)
+	c.Assert(group.NamedPorts[0].Name, Equals, "http")
+	c.Assert(group.NamedPorts[0].Port, Equals, int64(8080))
+
+	config.HealthCheck = &HealthCheck{Port: 9000}
+	c.Assert(config.InstanceGroup("foo").NamedPorts[0].Port, Equals, int64(8080))
+	config.HealthCheck = nil
+
+	bs := config.BackendService("qux", "us-central1", "foo")
+	c.Assert(bs.LoadBalancingScheme, Equals, "EXTERNAL")
+	c.Assert(bs.Protocol, Equals, "HTTP")
+	c.Assert(bs.PortName, Equals, "http")
+	c.Assert(config.Backend("qux", "baz", "foo").BalancingMode, Equals, "UTILIZATION")
+
+	service := "https://www.googleapis.com/compute/v1/projects/qux/global/backendServices/" + name
+	c.Assert(config.BackendServiceURL("qux", "us-central1", "foo"), Equals, service)
+
+	frontend := config.FrontendName("foo")
+	c.Assert(frontend, Matches, "docker-network-foo-bar-[0-9a-f]{8}")
+	c.Assert(frontend, Not(Equals), name)
+
+	m := config.UrlMap("qux", "foo", nil)
+	c.Assert(m.Name, Equals, frontend)
+	c.Assert(m.DefaultService, Equals, service)
+	c.Assert(m.HostRules[0].Hosts, DeepEquals, []string{"example.com"})
+	c.Assert(m.PathMatchers[0].PathRules[0].Paths, DeepEquals, []string{"/api/*"})
+	c.Assert(m.PathMatchers[0].PathRules[0].Service, Equals, service)
+
+	cert := config.SslCertificate("foo")
+	c.Assert(cert.Type, Equals, "MANAGED")
+	c.Assert(cert.Managed.Domains, DeepEquals, []string{"example.com"})
+
+	proxy := config.TargetHttpsProxy("qux", "foo")
+	c.Assert(proxy.Name, Equals, frontend)
+	c.Assert(proxy.UrlMap, Equals, "https://www.googleapis.com/compute/v1/projects/qux/global/urlMaps/"+frontend)
+	c.Assert(proxy.SslCertificates, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/qux/global/sslCertificates/" + name,
+	})
+
+	rules := config.GlobalForwardingRules("qux", "foo")
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].Name, Equals, frontend)
+	c.Assert(rules[0].PortRange, Equals, "443")
+	c.Assert(rules[0].IpVersion, Equals, "IPV4")
+	c.Assert(rules[0].Target, Equals, "https://www.googleapis.com/compute/v1/projects/qux/global/targetHttpsProxies/"+frontend)
+
+	fw := config.Firewall("foo")
+	c.Assert(fw.SourceRanges, DeepEquals, BackendServiceRanges)
+
+	config.Certificate = "existing"
+	c.Assert(config.SslCertificate("foo"), IsNil)
+	c.Assert(config.TargetHttpsProxy("qux", "foo").SslCertificates, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/qux/global/sslCertificates/existing",
+	})
+
+	config.Certificate = ""
+	config.Hosts = nil
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigIPVersion(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Address:   "web",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+		IPVersion: IPVersionDual,
+	}
+	c.Assert(config.Validate(), IsNil)
+	c.Assert(config.StaticAddress().IpVersion, Equals, "")
+
+	rules := config.GlobalForwardingRules("qux", "foo")
+	c.Assert(rules, HasLen, 2)
+	c.Assert(rules[0].Name, Equals, config.FrontendName("foo"))
+	c.Assert(rules[0].IpVersion, Equals, "IPV4")
+	c.Assert(rules[0].IPAddress, Equals, "web")
+	c.Assert(rules[1].Name, Equals, config.FrontendName("foo")+"-ipv6")
+	c.Assert(rules[1].IpVersion, Equals, "IPV6")
+	c.Assert(rules[1].IPAddress, Equals, "")
+
+	config.IPVersion = IPVersion6
+	c.Assert(config.StaticAddress().IpVersion, Equals, "IPV6")
+
+	rules = config.GlobalForwardingRules("qux", "foo")
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].Name, Equals, config.FrontendName("foo"))
+	c.Assert(rules[0].IpVersion, Equals, "IPV6")
+
+	config.IPVersion = "foo"
+	c.Assert(config.Validate(), NotNil)
+
+	config.IPVersion = IPVersionDual
+	config.Scheme = SchemeInternal
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigExternalIPv6(c *C) {
+	config := &NetworkConfig{
+		Container:  "bar",
+		Address:    "web",
+		Subnetwork: "frontend",
+		Ports:      []docker.Port{docker.Port("80/tcp"), docker.Port("443/tcp")},
+		IPVersion:  IPVersionDual,
+	}
+	c.Assert(config.Validate(), IsNil)
+	c.Assert(config.usesBackendService(), Equals, true)
+
+	bs := config.BackendService("qux", "us-central1", "foo")
+	c.Assert(bs.LoadBalancingScheme, Equals, "EXTERNAL")
+	c.Assert(bs.Protocol, Equals, "TCP")
+	c.Assert(bs.HealthChecks, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/qux/regions/us-central1/healthChecks/" + config.Name("foo"),
+	})
+
+	rules := config.ExternalForwardingRules("foo", "us-central1", "service")
+	c.Assert(rules, HasLen, 2)
+	c.Assert(rules[0].Name, Equals, config.Name("foo"))
+	c.Assert(rules[0].IpVersion, Equals, "IPV4")
+	c.Assert(rules[0].IPAddress, Equals, "web")
+	c.Assert(rules[0].Ports, DeepEquals, []string{"80", "443"})
+	c.Assert(rules[0].Subnetwork, Equals, "")
+	c.Assert(rules[1].Name, Equals, config.Name("foo")+"-ipv6")
+	c.Assert(rules[1].IpVersion, Equals, "IPV6")
+	c.Assert(rules[1].IPAddress, Equals, "")
+	c.Assert(rules[1].LoadBalancingScheme, Equals, "EXTERNAL")
+	c.Assert(rules[1].BackendService, Equals, "service")
+	c.Assert(rules[1].Subnetwork, Equals, "regions/us-central1/subnetworks/frontend")
+
+	fws := config.Firewalls("foo")
+	c.Assert(fws, HasLen, 2)
+	c.Assert(fws[0].SourceRanges, DeepEquals, []string{"0.0.0.0/0"})
+	c.Assert(fws[1].Name, Equals, config.Name("foo")+"-ipv6")
+	c.Assert(fws[1].SourceRanges, DeepEquals, []string{"::/0"})
+	c.Assert(fws[1].TargetTags, DeepEquals, []string{config.Name("foo")})
+	c.Assert(fws[1].Allowed, DeepEquals, fws[0].Allowed)
+
+	config.Source.Ranges = []string{"192.0.2.0/24", "2001:db8::/32"}
+	fws = config.Firewalls("foo")
+	c.Assert(fws[0].SourceRanges, DeepEquals, append([]string{"192.0.2.0/24"}, HealthCheckRanges...))
+	c.Assert(fws[1].SourceRanges, DeepEquals, []string{"2001:db8::/32", "2600:1901:8001::/48"})
+
+	config.IPVersion = IPVersion6
+	c.Assert(config.Validate(), NotNil)
+
+	config.Address = ""
+	c.Assert(config.Validate(), IsNil)
+
+	config.Source.Ranges = nil
+	fws = config.Firewalls("foo")
+	c.Assert(fws, HasLen, 1)
+	c.Assert(fws[0].SourceRanges, DeepEquals, []string{"::/0"})
+
+	config.Ports = append(config.Ports, docker.Port("53/udp"))
+	c.Assert(config.Validate(), NotNil)
+
+	name := config.Name("foo")
+	config.IPVersion = ""
+	c.Assert(config.Name("foo"), Not(Equals), name)
+	config.IPVersion = IPVersion4
+	c.Assert(config.Name("foo"), Not(Equals), name)
+	c.Assert(config.usesBackendService(), Equals, false)
+	c.Assert(config.IPv6Firewall("foo"), IsNil)
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigSecurityPolicy(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+	}
+
+	c.Assert(config.ManagedSecurityPolicy("foo"), IsNil)
+	c.Assert(config.SecurityPolicyURL("qux", "foo"), Equals, "")
+
+	config.SecurityPolicy = "waf"
+	c.Assert(config.Validate(), IsNil)
+	c.Assert(config.ManagedSecurityPolicy("foo"), IsNil)
+	c.Assert(config.SecurityPolicyURL("qux", "foo"), Equals,
+		"https://www.googleapis.com/compute/v1/projects/qux/global/securityPolicies/waf")
+
+	config.SecurityPolicy = ""
+	for i := 0; i < 12; i++ {
+		config.SecurityAllow = append(config.SecurityAllow, fmt.Sprintf("10.0.%d.0/24", i))
+	}
+	c.Assert(config.Validate(), IsNil)
+	c.Assert(config.SecurityPolicyURL("qux", "foo"), Equals,
+		"https://www.googleapis.com/compute/v1/projects/qux/global/securityPolicies/"+config.Name("foo"))
+
+	policy := config.ManagedSecurityPolicy("foo")
+	c.Assert(policy.Name, Equals, config.Name("foo"))
+	c.Assert(policy.Rules, HasLen, 3)
+	c.Assert(policy.Rules[0].Action, Equals, "allow")
+	c.Assert(policy.Rules[0].Priority, Equals, int64(1000))
+	c.Assert(policy.Rules[0].Match.Config.SrcIpRanges, HasLen, 10)
+	c.Assert(policy.Rules[1].Priority, Equals, int64(1001))
+	c.Assert(policy.Rules[1].Match.Config.SrcIpRanges, HasLen, 2)
+	c.Assert(policy.Rules[2].Action, Equals, "deny(403)")
+	c.Assert(policy.Rules[2].Priority, Equals, DefaultSecurityRulePriority)
+
+	config.SecurityPolicy = "waf"
+	c.Assert(config.Validate(), NotNil)
+
+	config.SecurityPolicy = ""
+	config.SecurityAllow = []string{"foo"}
+	c.Assert(config.Validate(), NotNil)
+
+	config.SecurityAllow = []string{"10.0.0.0/8"}
+	config.Scheme = SchemeExternal
+	c.Assert(config.Validate(), NotNil)
+}
+
+func (s *ConfigSuite) TestNetworkConfigDrainSeconds(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Scheme:    SchemeInternal,
+		Ports:     []docker.Port{docker.Port("80/tcp")},
+	}
+	c.Assert(config.BackendService("qux", "us-central1", "foo").ConnectionDraining, IsNil)
+
+	old := &compute.BackendService{
+		ConnectionDraining: &compute.ConnectionDraining{DrainingTimeoutSec: 300},
+	}
+	c.Assert(isConnectionDrainingOutdated(old, config.BackendService("qux", "us-central1", "foo")), Equals, false)
+
+	config.DrainSeconds = 30
+	c.Assert(config.BackendService("qux", "us-central1", "foo").ConnectionDraining.DrainingTimeoutSec, Equals, int64(30))
+	c.Assert(isConnectionDrainingOutdated(old, config.BackendService("qux", "us-central1", "foo")), Equals, true)
+}
+
+func (s *ConfigSuite) TestIsBackendHealthCheckOutdated(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Scheme:    SchemeInternal,
+		Ports:     []docker.Port{docker.Port("80/tcp")},
+	}
+
+	old := config.BackendHealthCheck("foo")
+	old.CheckIntervalSec = 5
+	c.Assert(isBackendHealthCheckOutdated(old, config.BackendHealthCheck("foo")), Equals, false)
+
+	config.HealthCheck = &HealthCheck{Path: "/health"}
+	c.Assert(isBackendHealthCheckOutdated(old, config.BackendHealthCheck("foo")), Equals, true)
+
+	old = config.BackendHealthCheck("foo")
+	c.Assert(isBackendHealthCheckOutdated(old, config.BackendHealthCheck("foo")), Equals, false)
+
+	config.HealthCheck.Interval = 10
+	c.Assert(isBackendHealthCheckOutdated(old, config.BackendHealthCheck("foo")), Equals, true)
+
+	config.HealthCheck = &HealthCheck{Path: "/health", Port: 8080}
+	c.Assert(isBackendHealthCheckOutdated(old, config.BackendHealthCheck("foo")), Equals, true)
+}
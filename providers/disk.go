@@ -12,6 +12,7 @@ type DiskProvider interface {
 	Attach(c *DiskConfig) error
 	Detach(c *DiskConfig) error
 	Delete(c *DiskConfig) error
+	Snapshot(c *DiskConfig, snapshot string) error
 	List() ([]*compute.Disk, error)
 }
 
@@ -78,6 +79,18 @@ func (d *Disk) Delete(c *DiskConfig) error {
 	return d.WaitDone(op)
 }
 
+func (d *Disk) Snapshot(c *DiskConfig, snapshot string) error {
+	op, err := d.s.Disks.CreateSnapshot(d.project, d.zone, c.Name, &compute.Snapshot{
+		Name:   snapshot,
+		Labels: map[string]string{LabelVolume: c.VolumeName()},
+	}).Do()
+	if err != nil {
+		return err
+	}
+
+	return d.WaitDone(op)
+}
+
 func (d *Disk) List() ([]*compute.Disk, error) {
 	op, err := d.s.Disks.List(d.project, d.zone).Do()
 	if err != nil {
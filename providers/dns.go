@@ -0,0 +1,214 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// updateDNSRecord upserts the A and AAAA records of the load balancer,
+// pointing to the addresses of the forwarding rules, read back from the rules
+// since the ephemeral addresses are assigned at creation. A record of a type
+// without addresses is deleted.
+func (n *Network) updateDNSRecord(c *NetworkConfig) (func() error, error) {
+	if c.DNS == nil {
+		return nil, nil
+	}
+
+	addresses, err := n.ruleAddresses(c)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := n.dnsZone(c.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	records := c.DNS.ResourceRecordSets(addresses)
+	change, undo := &dns.Change{}, &dns.Change{}
+	for _, t := range DNSRecordTypes {
+		old, err := n.getDNSRecord(zone, c.DNS.FQDN(), t)
+		if err != nil {
+			return nil, err
+		}
+
+		new := records[t]
+		if isDNSRecordUpdated(old, new) {
+			continue
+		}
+
+		if old != nil {
+			change.Deletions = append(change.Deletions, old)
+			undo.Additions = append(undo.Additions, old)
+		}
+
+		if new != nil {
+			change.Additions = append(change.Additions, new)
+			undo.Deletions = append(undo.Deletions, new)
+		}
+	}
+
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil, nil
+	}
+
+	if err := n.applyDNSChange(zone, change); err != nil {
+		return nil, err
+	}
+
+	log15.Info("DNS record updated", "name", c.DNS.FQDN(), "zone", zone, "addresses", addresses)
+	return func() error { return n.applyDNSChange(zone, undo) }, nil
+}
+
+// isDNSRecordUpdated returns true if both records are missing or equal.
+func isDNSRecordUpdated(old, new *dns.ResourceRecordSet) bool {
+	if old == nil || new == nil {
+		return old == nil && new == nil
+	}
+
+	return old.Ttl == new.Ttl && equalSet(old.Rrdatas, new.Rrdatas)
+}
+
+func (n *Network) deleteDNSRecord(c *NetworkConfig) error {
+	if c.DNS == nil {
+		return nil
+	}
+
+	zone, err := n.dnsZone(c.DNS)
+	if err != nil {
+		return err
+	}
+
+	change := &dns.Change{}
+	for _, t := range DNSRecordTypes {
+		old, err := n.getDNSRecord(zone, c.DNS.FQDN(), t)
+		if err != nil {
+			return err
+		}
+
+		if old != nil {
+			change.Deletions = append(change.Deletions, old)
+		}
+	}
+
+	if len(change.Deletions) == 0 {
+		return nil
+	}
+
+	return n.applyDNSChange(zone, change)
+}
+
+// ruleAddresses returns the distinct addresses of the forwarding rules.
+func (n *Network) ruleAddresses(c *NetworkConfig) ([]string, error) {
+	seen := make(map[string]bool, 0)
+	var addresses []string
+
+	for _, name := range n.forwardingRuleNames(c) {
+		var r *compute.ForwardingRule
+		var err error
+		if c.Scheme == SchemeHTTPS {
+			r, err = n.s.GlobalForwardingRules.Get(n.project, name).Do()
+		} else {
+			r, err = n.s.ForwardingRules.Get(n.project, n.region, name).Do()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[r.IPAddress] {
+			seen[r.IPAddress] = true
+			addresses = append(addresses, r.IPAddress)
+		}
+	}
+
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// dnsZone returns the managed zone of the record, the given one or the zone
+// with the longest DNS name matching the record.
+func (n *Network) dnsZone(d *DNS) (string, error) {
+	if d.Zone != "" {
+		return d.Zone, nil
+	}
+
+	var zones []*dns.ManagedZone
+	err := n.dns.ManagedZones.List(n.project).
+		Pages(context.Background(), func(l *dns.ManagedZonesListResponse) error {
+			zones = append(zones, l.ManagedZones...)
+			return nil
+		})
+
+	if err != nil {
+		return "", err
+	}
+
+	zone := matchDNSZone(zones, d.FQDN())
+	if zone == "" {
+		return "", fmt.Errorf("unable to find a managed zone for %q", d.FQDN())
+	}
+
+	return zone, nil
+}
+
+func matchDNSZone(zones []*dns.ManagedZone, fqdn string) string {
+	var match *dns.ManagedZone
+	for _, z := range zones {
+		if fqdn != z.DnsName && !strings.HasSuffix(fqdn, "."+z.DnsName) {
+			continue
+		}
+
+		if match == nil || len(z.DnsName) > len(match.DnsName) {
+			match = z
+		}
+	}
+
+	if match == nil {
+		return ""
+	}
+
+	return match.Name
+}
+
+func (n *Network) getDNSRecord(zone, fqdn, t string) (*dns.ResourceRecordSet, error) {
+	l, err := n.dns.ResourceRecordSets.List(n.project, zone).Name(fqdn).Type(t).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.Rrsets) == 0 {
+		return nil, nil
+	}
+
+	return l.Rrsets[0], nil
+}
+
+func (n *Network) applyDNSChange(zone string, change *dns.Change) error {
+	change, err := n.dns.Changes.Create(n.project, zone, change).Do()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for change.Status != "done" {
+		if time.Since(start) > MaxWaitDuration {
+			return fmt.Errorf("max. time reached waiting for DNS change %q", change.Id)
+		}
+
+		time.Sleep(time.Second)
+		change, err = n.dns.Changes.Get(n.project, zone, change.Id).Do()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
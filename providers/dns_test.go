@@ -0,0 +1,23 @@
+package providers
+
+import (
+	"google.golang.org/api/dns/v1"
+	. "gopkg.in/check.v1"
+)
+
+type DNSSuite struct{}
+
+var _ = Suite(&DNSSuite{})
+
+func (s *DNSSuite) TestMatchDNSZone(c *C) {
+	zones := []*dns.ManagedZone{
+		{Name: "example", DnsName: "example.com."},
+		{Name: "internal", DnsName: "internal.example.com."},
+		{Name: "other", DnsName: "ample.com."},
+	}
+
+	c.Assert(matchDNSZone(zones, "www.example.com."), Equals, "example")
+	c.Assert(matchDNSZone(zones, "db.internal.example.com."), Equals, "internal")
+	c.Assert(matchDNSZone(zones, "example.com."), Equals, "example")
+	c.Assert(matchDNSZone(zones, "www.example.org."), Equals, "")
+}
@@ -0,0 +1,297 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// createOrUpdateFirewalls creates the firewall rules of the load balancer, the
+// existing rules are patched when they differ from the config. The rules no
+// longer used by the config, as the IPv4 one of an IPv6 only load balancer,
+// are deleted.
+func (n *Network) createOrUpdateFirewalls(c *NetworkConfig) (func() error, error) {
+	var undos []func() error
+	undo := func() error {
+		for i := len(undos) - 1; i >= 0; i-- {
+			if err := undos[i](); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	project := n.firewallProject(c.Network)
+	used := make(map[string]bool, 0)
+	for _, rule := range c.Firewalls(n.instance) {
+		used[rule.Name] = true
+		u, err := n.createOrUpdateFirewall(project, rule)
+		if u != nil {
+			undos = append(undos, u)
+		}
+
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	// the rules no longer used by the config, as the deny ones without ranges
+	for _, name := range c.FirewallNames(n.instance) {
+		if used[name] {
+			continue
+		}
+
+		if err := n.deleteFirewall(project, name); err != nil {
+			return undo, err
+		}
+	}
+
+	if len(undos) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+func (n *Network) createOrUpdateFirewall(project string, rule *compute.Firewall) (func() error, error) {
+	old, err := n.s.Firewalls.Get(project, rule.Name).Do()
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		if err := n.insertFirewall(project, rule); err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteFirewall(project, rule.Name) }, nil
+	}
+
+	if isFirewallMoved(old, rule) {
+		log15.Info("firewall rule network changed, recreating", "firewall", rule.Name)
+		return n.recreateFirewall(project, old, rule)
+	}
+
+	if !isFirewallOutdated(old, rule) {
+		return nil, nil
+	}
+
+	log15.Info("firewall rule outdated, patching", "firewall", rule.Name)
+	if err := n.patchFirewall(project, rule); err != nil {
+		return nil, err
+	}
+
+	return func() error { return n.patchFirewall(project, old) }, nil
+}
+
+// recreateFirewall replaces the old rule by the new one, the network of a
+// rule can't be patched.
+func (n *Network) recreateFirewall(project string, old, new *compute.Firewall) (func() error, error) {
+	if err := n.deleteFirewall(project, old.Name); err != nil {
+		return nil, err
+	}
+
+	restore := func() error {
+		if err := n.deleteFirewall(project, new.Name); err != nil {
+			return err
+		}
+
+		return n.insertFirewall(project, old)
+	}
+
+	return restore, n.insertFirewall(project, new)
+}
+
+func (n *Network) insertFirewall(project string, rule *compute.Firewall) error {
+	op, err := n.s.Firewalls.Insert(project, rule).Do()
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func (n *Network) patchFirewall(project string, rule *compute.Firewall) error {
+	op, err := n.s.Firewalls.Patch(project, rule.Name, firewallPatch(rule)).Do()
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+// firewallPatch returns the modifiable fields of the rule, the empty fields
+// are sent too, so the values removed from the config are cleared.
+func firewallPatch(rule *compute.Firewall) *compute.Firewall {
+	logging := &compute.FirewallLogConfig{ForceSendFields: []string{"Enable"}}
+	if rule.LogConfig != nil {
+		logging.Enable = rule.LogConfig.Enable
+	}
+
+	return &compute.Firewall{
+		Name:         rule.Name,
+		Description:  rule.Description,
+		SourceRanges: rule.SourceRanges,
+		SourceTags:   rule.SourceTags,
+		TargetTags:   rule.TargetTags,
+		Allowed:      rule.Allowed,
+		Denied:       rule.Denied,
+		Priority:     rule.Priority,
+		LogConfig:    logging,
+		ForceSendFields: []string{
+			"Description", "SourceRanges", "SourceTags", "TargetTags",
+			"Allowed", "Denied", "Priority",
+		},
+	}
+}
+
+// isFirewallOutdated compares the fields of the rule managed by the config,
+// the lists are compared regardless of the order. The description is not
+// compared, it changes with every container.
+func isFirewallOutdated(old, new *compute.Firewall) bool {
+	oldLogging := old.LogConfig != nil && old.LogConfig.Enable
+	newLogging := new.LogConfig != nil && new.LogConfig.Enable
+
+	return !equalSet(old.SourceRanges, new.SourceRanges) ||
+		!equalSet(old.SourceTags, new.SourceTags) ||
+		!equalSet(old.TargetTags, new.TargetTags) ||
+		!equalSet(firewallAllowed(old.Allowed), firewallAllowed(new.Allowed)) ||
+		!equalSet(firewallDenied(old.Denied), firewallDenied(new.Denied)) ||
+		old.Priority != new.Priority ||
+		oldLogging != newLogging
+}
+
+// isFirewallMoved returns true if the rule belongs to another network, the
+// configs may hold either the name or the URL of the network.
+func isFirewallMoved(old, new *compute.Firewall) bool {
+	return !strings.HasSuffix(old.Network, new.Network)
+}
+
+func firewallAllowed(rules []*compute.FirewallAllowed) []string {
+	var s []string
+	for _, r := range rules {
+		s = append(s, r.IPProtocol+":"+strings.Join(r.Ports, ","))
+	}
+
+	return s
+}
+
+func firewallDenied(rules []*compute.FirewallDenied) []string {
+	var s []string
+	for _, r := range rules {
+		s = append(s, r.IPProtocol+":"+strings.Join(r.Ports, ","))
+	}
+
+	return s
+}
+
+func equalSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// firewallProject returns the project of the firewall rules, the host project
+// when the network, by default the one of the instance, is a shared VPC.
+func (n *Network) firewallProject(network string) string {
+	if network == "" {
+		network = n.network
+	}
+
+	if project := projectFromURL(network); project != "" {
+		return project
+	}
+
+	return n.project
+}
+
+// firewallProjects returns the projects where the firewall rules of the
+// instance may be: its own project, the one of its network, the ones of the
+// rules created since start and the ones of the given configs.
+func (n *Network) firewallProjects(configs ...*NetworkConfig) []string {
+	n.mu.Lock()
+	seen := map[string]bool{n.project: true, n.firewallProject(""): true}
+	for project := range n.projects {
+		seen[project] = true
+	}
+	n.mu.Unlock()
+
+	for _, c := range configs {
+		seen[n.firewallProject(c.Network)] = true
+	}
+
+	var projects []string
+	for project := range seen {
+		projects = append(projects, project)
+	}
+
+	sort.Strings(projects)
+	return projects
+}
+
+func (n *Network) addFirewallProject(project string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.projects == nil {
+		n.projects = make(map[string]bool, 0)
+	}
+
+	n.projects[project] = true
+}
+
+// findFirewall returns the first rule with the given name at the projects,
+// nil if there isn't any.
+func (n *Network) findFirewall(name string, projects []string) (*compute.Firewall, error) {
+	for _, project := range projects {
+		f, err := n.s.Firewalls.Get(project, name).Do()
+		if err == nil {
+			return f, nil
+		}
+
+		if !isNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (n *Network) deleteFirewalls(c *NetworkConfig) error {
+	project := n.firewallProject(c.Network)
+	for _, name := range c.FirewallNames(n.instance) {
+		if err := n.deleteFirewall(project, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *Network) deleteFirewall(project, name string) error {
+	op, err := n.s.Firewalls.Delete(project, name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type FirewallSuite struct{}
+
+var _ = Suite(&FirewallSuite{})
+
+func (s *FirewallSuite) TestIsFirewallOutdated(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports:     []docker.Port{docker.Port("80/tcp"), docker.Port("443/tcp")},
+	}
+	config.Source.Ranges = []string{"10.0.0.0/8", "192.168.0.0/16"}
+
+	old := config.Firewall("foo")
+	old.Network = "https://www.googleapis.com/compute/v1/projects/qux/global/networks/default"
+	old.SourceRanges = []string{"192.168.0.0/16", "10.0.0.0/8"}
+	c.Assert(isFirewallOutdated(old, config.Firewall("foo")), Equals, false)
+
+	config.Source.Ranges = []string{"10.0.0.0/8"}
+	c.Assert(isFirewallOutdated(old, config.Firewall("foo")), Equals, true)
+
+	config.Source.Ranges = []string{"10.0.0.0/8", "192.168.0.0/16"}
+	config.Logging = true
+	c.Assert(isFirewallOutdated(old, config.Firewall("foo")), Equals, true)
+
+	config.Logging = false
+	priority := int64(900)
+	config.Priority = &priority
+	c.Assert(isFirewallOutdated(old, config.Firewall("foo")), Equals, true)
+
+	config.Priority = nil
+	config.Container = "baz"
+	c.Assert(isFirewallOutdated(old, config.Firewall("foo")), Equals, false)
+}
+
+func (s *FirewallSuite) TestIsFirewallMoved(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports:     []docker.Port{docker.Port("80/tcp")},
+	}
+
+	old := config.Firewall("foo")
+	old.Network = "https://www.googleapis.com/compute/v1/projects/qux/global/networks/default"
+	c.Assert(isFirewallMoved(old, config.Firewall("foo")), Equals, false)
+
+	config.Network = "default"
+	c.Assert(isFirewallMoved(old, config.Firewall("foo")), Equals, false)
+
+	config.Network = "other"
+	c.Assert(isFirewallMoved(old, config.Firewall("foo")), Equals, true)
+}
+
+func (s *FirewallSuite) TestFirewallPatch(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Ports:     []docker.Port{docker.Port("80/tcp")},
+	}
+
+	patch := firewallPatch(config.Firewall("foo"))
+	c.Assert(patch.SourceTags, HasLen, 0)
+	c.Assert(patch.Network, Equals, "")
+	c.Assert(patch.ForceSendFields, DeepEquals, []string{
+		"Description", "SourceRanges", "SourceTags", "TargetTags",
+		"Allowed", "Denied", "Priority",
+	})
+	c.Assert(patch.LogConfig.ForceSendFields, DeepEquals, []string{"Enable"})
+}
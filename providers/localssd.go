@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+	"google.golang.org/api/compute/v1"
+)
+
+var (
+	LocalSSDPatterns = []string{
+		"/dev/disk/by-id/google-local-ssd-*",
+		"/dev/disk/by-id/google-local-nvme-ssd-*",
+	}
+	LocalSSDStateFile = "/var/lib/gce-docker/local-ssd.json"
+)
+
+// LocalSSD is a DiskProvider for the local SSDs of the instance, the SSDs
+// can't be created or attached on the fly, so they are assigned to the volumes
+// from the pool of free SSDs, the assignments are persisted at LocalSSDStateFile.
+type LocalSSD struct {
+	fs afero.Fs
+	sync.Mutex
+}
+
+func NewLocalSSD(fs afero.Fs) *LocalSSD {
+	return &LocalSSD{fs: fs}
+}
+
+func (l *LocalSSD) Create(c *DiskConfig) error {
+	l.Lock()
+	defer l.Unlock()
+
+	state, err := l.load()
+	if err != nil {
+		return err
+	}
+
+	if dev, ok := state[c.Name]; ok {
+		c.Device = dev
+		return nil
+	}
+
+	free, err := l.free(state)
+	if err != nil {
+		return err
+	}
+
+	if len(free) == 0 {
+		return fmt.Errorf("no free local SSD available for volume %q", c.Name)
+	}
+
+	state[c.Name] = free[0]
+	if err := l.save(state); err != nil {
+		return err
+	}
+
+	c.Device = free[0]
+	return nil
+}
+
+// Attach resolves the local SSD assigned to the volume, the SSDs are always
+// attached to the instance.
+func (l *LocalSSD) Attach(c *DiskConfig) error {
+	l.Lock()
+	defer l.Unlock()
+
+	state, err := l.load()
+	if err != nil {
+		return err
+	}
+
+	dev, ok := state[c.Name]
+	if !ok {
+		return fmt.Errorf("unable to find local SSD assigned to volume %q", c.Name)
+	}
+
+	c.Device = dev
+	return nil
+}
+
+func (l *LocalSSD) Detach(c *DiskConfig) error {
+	return nil
+}
+
+// Delete gives the local SSD back to the free set, the content of the SSD
+// should be wiped before.
+func (l *LocalSSD) Delete(c *DiskConfig) error {
+	l.Lock()
+	defer l.Unlock()
+
+	state, err := l.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := state[c.Name]; !ok {
+		return fmt.Errorf("unable to find local SSD assigned to volume %q", c.Name)
+	}
+
+	delete(state, c.Name)
+	return l.save(state)
+}
+
+func (l *LocalSSD) Snapshot(c *DiskConfig, snapshot string) error {
+	return fmt.Errorf("local SSDs don't support snapshots")
+}
+
+func (l *LocalSSD) List() ([]*compute.Disk, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	state, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range state {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var disks []*compute.Disk
+	for _, name := range names {
+		disks = append(disks, &compute.Disk{
+			Name:   name,
+			Type:   LocalSSDType,
+			Status: "READY",
+		})
+	}
+
+	return disks, nil
+}
+
+func (l *LocalSSD) free(state map[string]string) ([]string, error) {
+	assigned := make(map[string]bool, len(state))
+	for _, dev := range state {
+		assigned[dev] = true
+	}
+
+	var free []string
+	for _, pattern := range LocalSSDPatterns {
+		devs, err := afero.Glob(l.fs, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dev := range devs {
+			if strings.Contains(filepath.Base(dev), "-part") || assigned[dev] {
+				continue
+			}
+
+			free = append(free, dev)
+		}
+	}
+
+	sort.Strings(free)
+	return free, nil
+}
+
+func (l *LocalSSD) load() (map[string]string, error) {
+	state := make(map[string]string, 0)
+	content, err := afero.ReadFile(l.fs, LocalSSDStateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading local SSD state: %s", err)
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("error decoding local SSD state: %s", err)
+	}
+
+	return state, nil
+}
+
+func (l *LocalSSD) save(state map[string]string) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := l.fs.MkdirAll(filepath.Dir(LocalSSDStateFile), 0755); err != nil {
+		return err
+	}
+
+	return afero.WriteFile(l.fs, LocalSSDStateFile, content, 0644)
+}
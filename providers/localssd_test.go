@@ -0,0 +1,75 @@
+package providers
+
+import (
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type LocalSSDSuite struct {
+	fs afero.Fs
+	l  *LocalSSD
+}
+
+var _ = Suite(&LocalSSDSuite{})
+
+func (s *LocalSSDSuite) SetUpTest(c *C) {
+	s.fs = afero.NewMemMapFs()
+	for _, dev := range []string{
+		"/dev/disk/by-id/google-local-ssd-0",
+		"/dev/disk/by-id/google-local-ssd-0-part1",
+		"/dev/disk/by-id/google-local-nvme-ssd-1",
+	} {
+		c.Assert(afero.WriteFile(s.fs, dev, nil, 0644), IsNil)
+	}
+
+	s.l = NewLocalSSD(s.fs)
+}
+
+func (s *LocalSSDSuite) TestCreate(c *C) {
+	foo := &DiskConfig{Name: "foo", Type: LocalSSDType}
+	c.Assert(s.l.Create(foo), IsNil)
+	c.Assert(foo.Dev(), Equals, "/dev/disk/by-id/google-local-nvme-ssd-1")
+
+	bar := &DiskConfig{Name: "bar", Type: LocalSSDType}
+	c.Assert(s.l.Create(bar), IsNil)
+	c.Assert(bar.Dev(), Equals, "/dev/disk/by-id/google-local-ssd-0")
+
+	qux := &DiskConfig{Name: "qux", Type: LocalSSDType}
+	c.Assert(s.l.Create(qux), NotNil)
+
+	again := &DiskConfig{Name: "foo", Type: LocalSSDType}
+	c.Assert(s.l.Create(again), IsNil)
+	c.Assert(again.Dev(), Equals, "/dev/disk/by-id/google-local-nvme-ssd-1")
+}
+
+func (s *LocalSSDSuite) TestAttach(c *C) {
+	config := &DiskConfig{Name: "foo"}
+	c.Assert(s.l.Attach(config), NotNil)
+
+	c.Assert(s.l.Create(&DiskConfig{Name: "foo", Type: LocalSSDType}), IsNil)
+	c.Assert(s.l.Attach(config), IsNil)
+	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-local-nvme-ssd-1")
+}
+
+func (s *LocalSSDSuite) TestDelete(c *C) {
+	c.Assert(s.l.Create(&DiskConfig{Name: "foo", Type: LocalSSDType}), IsNil)
+	c.Assert(s.l.Create(&DiskConfig{Name: "bar", Type: LocalSSDType}), IsNil)
+	c.Assert(s.l.Delete(&DiskConfig{Name: "foo"}), IsNil)
+	c.Assert(s.l.Delete(&DiskConfig{Name: "foo"}), NotNil)
+
+	config := &DiskConfig{Name: "qux", Type: LocalSSDType}
+	c.Assert(s.l.Create(config), IsNil)
+	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-local-nvme-ssd-1")
+}
+
+func (s *LocalSSDSuite) TestList(c *C) {
+	c.Assert(s.l.Create(&DiskConfig{Name: "foo", Type: LocalSSDType}), IsNil)
+	c.Assert(s.l.Create(&DiskConfig{Name: "bar", Type: LocalSSDType}), IsNil)
+
+	disks, err := NewLocalSSD(s.fs).List()
+	c.Assert(err, IsNil)
+	c.Assert(disks, HasLen, 2)
+	c.Assert(disks[0].Name, Equals, "bar")
+	c.Assert(disks[0].Type, Equals, LocalSSDType)
+	c.Assert(disks[1].Name, Equals, "foo")
+}
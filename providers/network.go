@@ -2,13 +2,35 @@ package providers
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/net/context"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
 	"google.golang.org/api/googleapi"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
+// MaxConflictRetries is the max. number of retries of an update guarded by a
+// fingerprint, when the resource is modified concurrently.
+var MaxConflictRetries = 5
+
+// ConflictRetryDelay is the base delay between the retries of an update
+// guarded by a fingerprint, doubled on every retry.
+var ConflictRetryDelay = 500 * time.Millisecond
+
+// conflictBackoff waits before retrying a conflicting update, with a random
+// jitter, so the instances updating the same resource don't retry in lockstep.
+func conflictBackoff(retries int) {
+	d := ConflictRetryDelay << uint(retries)
+	time.Sleep(d + time.Duration(rand.Int63n(int64(d)+1)))
+}
+
 type NetworkProvider interface {
 	Create(c *DiskConfig) error
 	Delete(c *DiskConfig) error
@@ -16,6 +38,15 @@ type NetworkProvider interface {
 
 type Network struct {
 	Client
+	dns *dns.Service
+	// network is the URL of the VPC network of the instance
+	network string
+	// subnetwork is the URL of the subnetwork of the instance
+	subnetwork string
+	// projects are the projects of the firewall rules created since start,
+	// besides the ones of the instance and its network
+	projects map[string]bool
+	mu       sync.Mutex
 }
 
 func NewNetwork(c *http.Client, project, zone, instance string) (*Network, error) {
@@ -24,68 +55,306 @@ func NewNetwork(c *http.Client, project, zone, instance string) (*Network, error
 		return nil, err
 	}
 
-	return &Network{Client: *client}, nil
+	d, err := dns.New(c)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Network{Client: *client, dns: d}
+	return n, n.loadNetwork()
 }
 
+func (n *Network) loadNetwork() error {
+	i, err := n.s.Instances.Get(n.project, n.zone, n.instance).Do()
+	if err != nil {
+		return fmt.Errorf("error retrieving network from instance: %s", err)
+	}
+
+	if len(i.NetworkInterfaces) != 0 {
+		n.network = i.NetworkInterfaces[0].Network
+		n.subnetwork = i.NetworkInterfaces[0].Subnetwork
+	}
+
+	return nil
+}
+
+// Create creates or updates the resources of the load balancer. When a step
+// fails, the changes made by the previous steps are rolled back.
 func (n *Network) Create(c *NetworkConfig) error {
 	if err := c.Validate(); err != nil {
 		return err
 	}
 
-	if err := n.updateInstanceTags(c); err != nil {
+	if c.Network == "" {
+		c.Network = n.network
 	}
 
-	if err := n.createOrUpdateTargetPool(c); err != nil {
-		return fmt.Errorf("error creating/updating target pool: %s", err)
+	if c.Project == "" {
+		c.Project = n.project
 	}
 
-	if err := n.createForwardingRules(c); err != nil {
-		return fmt.Errorf("error creating forwarding rule: %s", err)
+	n.addFirewallProject(n.firewallProject(c.Network))
+	if c.Scheme == SchemeInternal && len(c.Source.Ranges) == 0 && len(c.Source.Tags) == 0 {
+		ranges, err := n.networkRanges(c)
+		if err != nil {
+			return fmt.Errorf("error listing network ranges: %s", err)
+		}
+
+		c.Source.Ranges = ranges
 	}
 
-	if err := n.createOrUpdateFirewall(c); err != nil {
-		return fmt.Errorf("error creating firewall rule: %s", err)
+	// the IPv6 forwarding rules take their address from a subnetwork
+	if c.externalBackend() && c.Subnetwork == "" {
+		c.Subnetwork = n.subnetwork
+	}
+
+	t := &transaction{}
+	for _, s := range n.steps(c) {
+		do := s.do
+		if err := t.Do(s.name, func() (func() error, error) { return do(c) }); err != nil {
+			return t.Rollback(err)
+		}
 	}
 
 	return nil
 }
 
-func (n *Network) updateInstanceTags(c *NetworkConfig) error {
-	i, err := n.s.Instances.Get(n.project, n.zone, n.instance).Do()
-	if err != nil {
-		return err
+// networkRanges returns the primary and secondary ranges of the subnetworks of
+// the network, the default sources of an internal load balancer.
+func (n *Network) networkRanges(c *NetworkConfig) ([]string, error) {
+	var ranges []string
+	network := c.networkURL()
+	err := n.s.Subnetworks.AggregatedList(n.firewallProject(c.Network)).
+		Pages(context.Background(), func(l *compute.SubnetworkAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, s := range scoped.Subnetworks {
+					if !strings.HasSuffix(s.Network, network) {
+						continue
+					}
+
+					ranges = append(ranges, s.IpCidrRange)
+					for _, r := range s.SecondaryIpRanges {
+						ranges = append(ranges, r.IpCidrRange)
+					}
+				}
+			}
+
+			return nil
+		})
+
+	return ranges, err
+}
+
+type networkStep struct {
+	name string
+	do   func(*NetworkConfig) (func() error, error)
+}
+
+// steps returns the steps creating the load balancer of the config scheme. The
+// tag is added once the firewall exists, otherwise it could be pruned as stale
+// by a concurrent deletion.
+func (n *Network) steps(c *NetworkConfig) []networkStep {
+	if c.externalBackend() {
+		return []networkStep{
+			{"creating/updating instance group", n.createOrUpdateInstanceGroup},
+			{"creating/updating health check", n.createOrUpdateBackendHealthCheck},
+			{"creating/updating backend service", n.createOrUpdateBackendService},
+			{"reserving static address", n.reserveAddress},
+			{"creating forwarding rule", n.createExternalForwardingRules},
+			{"creating/updating firewall rules", n.createOrUpdateFirewalls},
+			{"updating DNS record", n.updateDNSRecord},
+			{"updating instance tags", n.updateInstanceTags},
+		}
+	}
+
+	switch c.Scheme {
+	case SchemeHTTPS:
+		return []networkStep{
+			{"creating/updating instance group", n.createOrUpdateInstanceGroup},
+			{"creating/updating health check", n.createOrUpdateBackendHealthCheck},
+			{"creating/updating backend service", n.createOrUpdateBackendService},
+			{"attaching security policy", n.attachSecurityPolicy},
+			{"creating/updating URL map", n.createOrUpdateUrlMap},
+			{"creating SSL certificate", n.createSslCertificate},
+			{"creating target HTTPS proxy", n.createTargetHttpsProxy},
+			{"reserving static address", n.reserveAddress},
+			{"creating forwarding rule", n.createGlobalForwardingRules},
+			{"creating/updating firewall rules", n.createOrUpdateFirewalls},
+			{"updating DNS record", n.updateDNSRecord},
+			{"updating instance tags", n.updateInstanceTags},
+		}
+	case SchemeInternal:
+		return []networkStep{
+			{"creating/updating instance group", n.createOrUpdateInstanceGroup},
+			{"creating/updating health check", n.createOrUpdateBackendHealthCheck},
+			{"creating/updating backend service", n.createOrUpdateBackendService},
+			{"creating forwarding rule", n.createInternalForwardingRule},
+			{"creating/updating firewall rules", n.createOrUpdateFirewalls},
+			{"updating DNS record", n.updateDNSRecord},
+			{"updating instance tags", n.updateInstanceTags},
+		}
+	}
+
+	return []networkStep{
+		{"creating/updating health check", n.createOrUpdateHealthCheck},
+		{"creating/updating target pool", n.createOrUpdateTargetPool},
+		{"reserving static address", n.reserveAddress},
+		{"creating forwarding rule", n.createForwardingRules},
+		{"creating/updating firewall rules", n.createOrUpdateFirewalls},
+		{"updating DNS record", n.updateDNSRecord},
+		{"updating instance tags", n.updateInstanceTags},
 	}
+}
+
+// forwardingRuleNames returns the names of the forwarding rules of the config
+// scheme.
+func (n *Network) forwardingRuleNames(c *NetworkConfig) []string {
+	var names []string
+	switch {
+	case c.Scheme == SchemeHTTPS:
+		for _, rule := range c.GlobalForwardingRules(n.project, n.instance) {
+			names = append(names, rule.Name)
+		}
+
+		return names
+	case c.Scheme == SchemeInternal:
+		return []string{c.Name(n.instance)}
+	case c.externalBackend():
+		for _, version := range c.ipVersions() {
+			names = append(names, c.forwardingRuleName(n.instance, version))
+		}
 
+		return names
+	}
+
+	targetPoolURL := TargetPoolURL(n.project, n.region, c.Name(n.instance))
+	for _, rule := range c.ForwardingRule(n.instance, targetPoolURL) {
+		names = append(names, rule.Name)
+	}
+
+	return names
+}
+
+func (n *Network) updateInstanceTags(c *NetworkConfig) (func() error, error) {
 	tag := c.Name(n.instance)
-	if contains(i.Tags.Items, tag) {
-		return nil
+	added := false
+	err := n.setInstanceTags(func(tags []string) ([]string, bool) {
+		if contains(tags, tag) {
+			return tags, false
+		}
+
+		added = true
+		return append(tags, tag), true
+	})
+
+	if !added {
+		return nil, err
 	}
 
-	op, err := n.s.Instances.SetTags(n.project, n.zone, n.instance, &compute.Tags{
-		Items:       append(i.Tags.Items, tag),
-		Fingerprint: i.Tags.Fingerprint,
-	}).Do()
+	return func() error {
+		return n.setInstanceTags(func(tags []string) ([]string, bool) {
+			return removeTag(tags, tag)
+		})
+	}, err
+}
+
+// setInstanceTags replaces the tags of the instance by the ones returned by
+// update, if any change. The fingerprint of the tags guards against concurrent
+// modifications, on conflict the update is retried with the current tags.
+func (n *Network) setInstanceTags(update func(tags []string) ([]string, bool)) error {
+	for retries := 0; ; retries++ {
+		i, err := n.s.Instances.Get(n.project, n.zone, n.instance).Do()
+		if err != nil {
+			return err
+		}
+
+		tags, changed := update(i.Tags.Items)
+		if !changed {
+			return nil
+		}
+
+		op, err := n.s.Instances.SetTags(n.project, n.zone, n.instance, &compute.Tags{
+			Items:       tags,
+			Fingerprint: i.Tags.Fingerprint,
+		}).Do()
+
+		if err == nil {
+			return n.WaitDone(op)
+		}
+
+		if !isConflict(err) || retries >= MaxConflictRetries {
+			return err
+		}
+
+		log15.Warn("instance tags modified concurrently, retrying", "instance", n.instance, "retries", retries)
+		conflictBackoff(retries)
+	}
+}
+
+func (n *Network) createOrUpdateHealthCheck(c *NetworkConfig) (func() error, error) {
+	new := c.HttpHealthCheck(n.instance)
+	if new == nil {
+		return nil, nil
+	}
 
+	old, err := n.s.HttpHealthChecks.Get(n.project, new.Name).Do()
 	if err != nil {
-		return err
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
+			return nil, err
+		}
+
+		op, err := n.s.HttpHealthChecks.Insert(n.project, new).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteHealthCheck(c) }, n.WaitDone(op)
 	}
 
-	return n.WaitDone(op)
+	if !isHealthCheckOutdated(old, new) {
+		return nil, nil
+	}
 
+	op, err := n.s.HttpHealthChecks.Update(n.project, new.Name, new).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, n.WaitDone(op)
+}
+
+// isHealthCheckOutdated compares only the fields with a value, the fields
+// without value take the defaults at creation.
+func isHealthCheckOutdated(old, new *compute.HttpHealthCheck) bool {
+	return old.RequestPath != new.RequestPath ||
+		old.Port != new.Port ||
+		(new.CheckIntervalSec != 0 && old.CheckIntervalSec != new.CheckIntervalSec) ||
+		(new.HealthyThreshold != 0 && old.HealthyThreshold != new.HealthyThreshold) ||
+		(new.UnhealthyThreshold != 0 && old.UnhealthyThreshold != new.UnhealthyThreshold)
 }
 
-func (n *Network) createOrUpdateTargetPool(c *NetworkConfig) error {
+func (n *Network) createOrUpdateTargetPool(c *NetworkConfig) (func() error, error) {
 	new := c.TargetPool(n.project, n.zone, n.instance)
 	old, err := n.s.TargetPools.Get(n.project, n.region, new.Name).Do()
 	if err != nil {
 		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-			return err
+			return nil, err
 		}
 
-		return n.createTargetPool(new)
+		return func() error { return n.deleteTargetPool(c) }, n.createTargetPool(new)
+	}
+
+	// the instance is removed on rollback only if it wasn't already a member
+	if contains(old.Instances, InstanceURL(n.project, n.zone, n.instance)) {
+		return nil, n.updateTargetPool(old, new)
 	}
 
-	return n.updateTargetPool(old, new)
+	undo := func() error {
+		_, err := n.removeInstance(c)
+		return err
+	}
+
+	return undo, n.updateTargetPool(old, new)
 }
 
 func (n *Network) createTargetPool(pool *compute.TargetPool) error {
@@ -98,6 +367,24 @@ func (n *Network) createTargetPool(pool *compute.TargetPool) error {
 }
 
 func (n *Network) updateTargetPool(old, new *compute.TargetPool) error {
+	for _, hc := range new.HealthChecks {
+		if contains(old.HealthChecks, hc) {
+			continue
+		}
+
+		op, err := n.s.TargetPools.AddHealthCheck(n.project, n.region, new.Name, &compute.TargetPoolsAddHealthCheckRequest{
+			HealthChecks: []*compute.HealthCheckReference{{HealthCheck: hc}},
+		}).Do()
+
+		if err != nil {
+			return err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return err
+		}
+	}
+
 	op, err := n.s.TargetPools.AddInstance(n.project, n.region, new.Name, &compute.TargetPoolsAddInstanceRequest{
 		Instances: []*compute.InstanceReference{{
 			Instance: InstanceURL(n.project, n.zone, n.instance),
@@ -111,42 +398,167 @@ func (n *Network) updateTargetPool(old, new *compute.TargetPool) error {
 	return n.WaitDone(op)
 }
 
-func (n *Network) createForwardingRules(c *NetworkConfig) error {
+// createForwardingRules creates the forwarding rules of the target pool, the
+// rules of the pool no longer in the config, as the ones of single ports
+// merged into a range, are deleted first, since they may hold the same address
+// and ports.
+func (n *Network) createForwardingRules(c *NetworkConfig) (func() error, error) {
+	var created, deleted []*compute.ForwardingRule
+	undo := func() error {
+		for _, rule := range created {
+			if err := n.deleteForwardingRule(rule); err != nil {
+				return err
+			}
+		}
+
+		for _, rule := range deleted {
+			if _, err := n.createForwardingRule(outdatedForwardingRule(rule)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	targetPoolURL := TargetPoolURL(n.project, n.region, c.Name(n.instance))
-	for _, rule := range c.ForwardingRule(n.instance, targetPoolURL) {
-		if err := n.createForwardingRule(rule); err != nil {
-			return err
+	rules := c.ForwardingRule(n.instance, targetPoolURL)
+	outdated, err := n.outdatedForwardingRules(c.Name(n.instance), rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range outdated {
+		log15.Info("forwarding rule outdated, deleting", "rule", rule.Name)
+		if err := n.deleteForwardingRule(rule); err != nil {
+			return undo, err
 		}
+
+		deleted = append(deleted, rule)
 	}
 
-	return nil
+	for _, rule := range rules {
+		ok, err := n.createForwardingRule(rule)
+		if ok {
+			created = append(created, rule)
+		}
+
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	if len(created) == 0 && len(deleted) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+// outdatedForwardingRules returns the existing rules of the target pool not
+// present at the given ones.
+func (n *Network) outdatedForwardingRules(pool string, rules []*compute.ForwardingRule) ([]*compute.ForwardingRule, error) {
+	names := make(map[string]bool, 0)
+	for _, rule := range rules {
+		names[rule.Name] = true
+	}
+
+	var outdated []*compute.ForwardingRule
+	err := n.s.ForwardingRules.List(n.project, n.region).
+		Filter(fmt.Sprintf("name eq %s-.*", pool)).
+		Pages(context.Background(), func(l *compute.ForwardingRuleList) error {
+			for _, rule := range l.Items {
+				if ResourceName(rule.Target) == pool && !names[rule.Name] {
+					outdated = append(outdated, rule)
+				}
+			}
+
+			return nil
+		})
+
+	return outdated, err
+}
+
+// outdatedForwardingRule returns the fields of an existing rule required to
+// insert it again.
+func outdatedForwardingRule(rule *compute.ForwardingRule) *compute.ForwardingRule {
+	return &compute.ForwardingRule{
+		Name:        rule.Name,
+		Description: rule.Description,
+		IPAddress:   rule.IPAddress,
+		IPProtocol:  rule.IPProtocol,
+		PortRange:   rule.PortRange,
+		Target:      rule.Target,
+	}
 }
 
-func (n *Network) createForwardingRule(rule *compute.ForwardingRule) error {
+// createForwardingRule creates the rule if it doesn't exist, and returns if
+// the rule was inserted.
+func (n *Network) createForwardingRule(rule *compute.ForwardingRule) (bool, error) {
 	if err := n.resolveForwardingRule(rule); err != nil {
-		return err
+		return false, err
 	}
 
 	_, err := n.s.ForwardingRules.Get(n.project, n.region, rule.Name).Do()
 	if err == nil {
-		return nil
+		return false, nil
 	}
 
 	if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-		return err
+		return false, err
 	}
 
 	op, err := n.s.ForwardingRules.Insert(n.project, n.region, rule).Do()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return n.WaitDone(op)
+	return true, n.WaitDone(op)
+}
+
+// reserveAddress reserves the static address named at the config if it
+// doesn't exist, the address is labeled as managed by gce-docker. The address
+// is global for the https scheme and regional otherwise.
+func (n *Network) reserveAddress(c *NetworkConfig) (func() error, error) {
+	addr := c.StaticAddress()
+	if addr == nil {
+		return nil, nil
+	}
+
+	_, err := n.getAddress(c, addr.Name)
+	if err == nil || !isNotFound(err) {
+		return nil, err
+	}
+
+	var op *compute.Operation
+	if c.Scheme == SchemeHTTPS {
+		op, err = n.s.GlobalAddresses.Insert(n.project, addr).Do()
+	} else {
+		op, err = n.s.Addresses.Insert(n.project, n.region, addr).Do()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	log15.Info("static address reserved", "address", addr.Name)
+	return func() error { return n.deleteAddress(c, addr.Name) }, n.WaitDone(op)
+}
+
+func (n *Network) getAddress(c *NetworkConfig, name string) (*compute.Address, error) {
+	if c.Scheme == SchemeHTTPS {
+		return n.s.GlobalAddresses.Get(n.project, name).Do()
+	}
+
+	return n.s.Addresses.Get(n.project, n.region, name).Do()
 }
 
 func (n *Network) resolveForwardingRule(rule *compute.ForwardingRule) error {
-	test := net.ParseIP(rule.IPAddress)
-	if test.To4() != nil {
+	if rule.IPAddress == "" {
+		return nil
+	}
+
+	// IPv4 and IPv6 literals are used as is, otherwise it's an address name
+	if net.ParseIP(rule.IPAddress) != nil {
 		return nil
 	}
 
@@ -159,26 +571,76 @@ func (n *Network) resolveForwardingRule(rule *compute.ForwardingRule) error {
 	return nil
 }
 
-func (n *Network) createOrUpdateFirewall(c *NetworkConfig) error {
-	rule := c.Firewall(n.instance)
-	if _, err := n.s.Firewalls.Get(n.project, rule.Name).Do(); err != nil {
-		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-			return err
+// Orphans returns the configs of the load balancers of the instance, found by
+// its tags, that don't belong to any of the given active configs.
+func (n *Network) Orphans(active []*NetworkConfig) ([]*NetworkConfig, error) {
+	names := make(map[string]bool, len(active))
+	for _, c := range active {
+		names[c.Name(n.instance)] = true
+	}
+
+	i, err := n.s.Instances.Get(n.project, n.zone, n.instance).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*NetworkConfig
+	for _, tag := range i.Tags.Items {
+		if !strings.HasPrefix(tag, NetworkPrefix) || names[tag] {
+			continue
 		}
 
-		op, err := n.s.Firewalls.Insert(n.project, rule).Do()
+		f, err := n.findFirewall(tag, n.firewallProjects(active...))
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		if f == nil {
+			continue
 		}
 
-		return n.WaitDone(op)
+		c, err := DecodeNetworkConfig(f.Description)
+		if err != nil {
+			log15.Warn("unable to decode network config", "firewall", f.Name, "error", err)
+			continue
+		}
+
+		orphans = append(orphans, c)
 	}
 
-	return nil
+	return orphans, nil
 }
 
+// Delete removes the instance from the load balancer, the resources shared by
+// the group are deleted only when no instance remains in the target pool.
 func (n *Network) Delete(c *NetworkConfig) error {
-	if err := n.deleteFirewall(c); err != nil {
+	switch {
+	case c.Scheme == SchemeInternal:
+		return n.deleteInternal(c)
+	case c.Scheme == SchemeHTTPS:
+		return n.deleteHTTPS(c)
+	case c.externalBackend():
+		return n.deleteExternalBackend(c)
+	}
+
+	empty, err := n.removeInstance(c)
+	if err != nil {
+		return fmt.Errorf("error removing instance from target pool: %s", err)
+	}
+
+	if err := n.removeInstanceTag(c); err != nil {
+		return fmt.Errorf("error removing instance tag: %s", err)
+	}
+
+	if !empty {
+		return nil
+	}
+
+	if err := n.deleteDNSRecord(c); err != nil {
+		return fmt.Errorf("error deleting DNS record: %s", err)
+	}
+
+	if err := n.deleteFirewalls(c); err != nil {
 		return err
 	}
 
@@ -186,26 +648,159 @@ func (n *Network) Delete(c *NetworkConfig) error {
 		return err
 	}
 
+	if err := n.releaseAddress(c); err != nil {
+		return fmt.Errorf("error releasing static address: %s", err)
+	}
+
 	if err := n.deleteTargetPool(c); err != nil {
 		return err
 	}
 
+	if err := n.deleteHealthCheck(c); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (n *Network) deleteFirewall(c *NetworkConfig) error {
-	rule := c.Firewall(n.instance)
-	op, err := n.s.Firewalls.Delete(n.project, rule.Name).Do()
+// Disable removes the instance from the target pool or the instance group of
+// the load balancer, keeping the rest of resources. The instance is added back
+// by Create.
+func (n *Network) Disable(c *NetworkConfig) error {
+	if c.usesBackendService() {
+		if _, err := n.removeGroupInstance(c); err != nil {
+			return fmt.Errorf("error removing instance from instance group: %s", err)
+		}
+
+		return nil
+	}
+
+	if _, err := n.removeInstance(c); err != nil {
+		return fmt.Errorf("error removing instance from target pool: %s", err)
+	}
+
+	return nil
+}
+
+// removeInstance removes the instance from the target pool and returns if the
+// pool is empty afterwards.
+func (n *Network) removeInstance(c *NetworkConfig) (bool, error) {
+	name := c.Name(n.instance)
+	pool, err := n.s.TargetPools.Get(n.project, n.region, name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	instance := InstanceURL(n.project, n.zone, n.instance)
+	if contains(pool.Instances, instance) {
+		op, err := n.s.TargetPools.RemoveInstance(n.project, n.region, name, &compute.TargetPoolsRemoveInstanceRequest{
+			Instances: []*compute.InstanceReference{{Instance: instance}},
+		}).Do()
+
+		if err != nil {
+			return false, err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return false, err
+		}
+	}
+
+	// the pool is retrieved again, since other instances of the group may be
+	// removed in the meantime
+	pool, err = n.s.TargetPools.Get(n.project, n.region, name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return len(pool.Instances) == 0, nil
+}
+
+// removeInstanceTag removes the tag of the load balancer from the instance,
+// along with the stale tags whose firewall doesn't exist anymore.
+func (n *Network) removeInstanceTag(c *NetworkConfig) error {
+	firewalls, err := n.listFirewalls(n.firewallProjects(c))
 	if err != nil {
 		return err
 	}
 
-	return n.WaitDone(op)
+	tag := c.Name(n.instance)
+	return n.setInstanceTags(func(tags []string) ([]string, bool) {
+		return pruneTags(tags, tag, firewalls)
+	})
 }
 
+func pruneTags(tags []string, tag string, firewalls map[string]bool) ([]string, bool) {
+	var pruned []string
+	for _, t := range tags {
+		if t == tag {
+			continue
+		}
+
+		if strings.HasPrefix(t, NetworkPrefix) && !firewalls[t] {
+			log15.Info("pruning stale instance tag", "tag", t)
+			continue
+		}
+
+		pruned = append(pruned, t)
+	}
+
+	return pruned, len(pruned) != len(tags)
+}
+
+func removeTag(tags []string, tag string) ([]string, bool) {
+	var removed []string
+	for _, t := range tags {
+		if t != tag {
+			removed = append(removed, t)
+		}
+	}
+
+	return removed, len(removed) != len(tags)
+}
+
+// listFirewalls returns the names of the firewalls created by gce-docker at
+// the given projects.
+func (n *Network) listFirewalls(projects []string) (map[string]bool, error) {
+	firewalls := make(map[string]bool, 0)
+	for _, project := range projects {
+		err := n.s.Firewalls.List(project).
+			Filter(fmt.Sprintf("name eq %s.*", NetworkPrefix)).
+			Pages(context.Background(), func(l *compute.FirewallList) error {
+				for _, f := range l.Items {
+					firewalls[f.Name] = true
+				}
+
+				return nil
+			})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return firewalls, nil
+}
+
+// deleteForwardingRules deletes the forwarding rules of the config, along with
+// any other rule of the target pool left by a previous config.
 func (n *Network) deleteForwardingRules(c *NetworkConfig) error {
 	targetPoolURL := TargetPoolURL(n.project, n.region, c.Name(n.instance))
-	for _, rule := range c.ForwardingRule(n.instance, targetPoolURL) {
+	rules := c.ForwardingRule(n.instance, targetPoolURL)
+	outdated, err := n.outdatedForwardingRules(c.Name(n.instance), rules)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range append(rules, outdated...) {
 		if err := n.deleteForwardingRule(rule); err != nil {
 			return err
 		}
@@ -217,6 +812,55 @@ func (n *Network) deleteForwardingRules(c *NetworkConfig) error {
 func (n *Network) deleteForwardingRule(rule *compute.ForwardingRule) error {
 	op, err := n.s.ForwardingRules.Delete(n.project, n.region, rule.Name).Do()
 	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+// releaseAddress releases the static address only when requested and if it
+// was reserved by gce-docker.
+func (n *Network) releaseAddress(c *NetworkConfig) error {
+	addr := c.StaticAddress()
+	if addr == nil || !c.ReleaseAddress {
+		return nil
+	}
+
+	current, err := n.getAddress(c, addr.Name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if current.Labels[LabelManaged] != "true" {
+		log15.Warn("static address not reserved by gce-docker, skipping release", "address", addr.Name)
+		return nil
+	}
+
+	return n.deleteAddress(c, addr.Name)
+}
+
+func (n *Network) deleteAddress(c *NetworkConfig, name string) error {
+	var op *compute.Operation
+	var err error
+	if c.Scheme == SchemeHTTPS {
+		op, err = n.s.GlobalAddresses.Delete(n.project, name).Do()
+	} else {
+		op, err = n.s.Addresses.Delete(n.project, n.region, name).Do()
+	}
+
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
 		return err
 	}
 
@@ -227,6 +871,28 @@ func (n *Network) deleteTargetPool(c *NetworkConfig) error {
 	pool := c.TargetPool(n.project, n.zone, n.instance)
 	op, err := n.s.TargetPools.Delete(n.project, n.region, pool.Name).Do()
 	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func (n *Network) deleteHealthCheck(c *NetworkConfig) error {
+	hc := c.HttpHealthCheck(n.instance)
+	if hc == nil {
+		return nil
+	}
+
+	op, err := n.s.HttpHealthChecks.Delete(n.project, hc.Name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
 		return err
 	}
 
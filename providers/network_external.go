@@ -0,0 +1,75 @@
+package providers
+
+import (
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// createExternalForwardingRules creates the forwarding rules of an external
+// load balancer serving IPv6, one for every IP version.
+func (n *Network) createExternalForwardingRules(c *NetworkConfig) (func() error, error) {
+	serviceURL := RegionBackendServiceURL(n.project, n.region, c.Name(n.instance))
+
+	var created []*compute.ForwardingRule
+	undo := func() error {
+		for _, rule := range created {
+			if err := n.deleteForwardingRule(rule); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, rule := range c.ExternalForwardingRules(n.instance, n.region, serviceURL) {
+		ok, err := n.createForwardingRule(rule)
+		if ok {
+			created = append(created, rule)
+		}
+
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	if len(created) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+// deleteExternalBackend removes the instance from the backends of an external
+// load balancer serving IPv6, the resources shared by the load balancer are
+// deleted when no backend remains in the service.
+func (n *Network) deleteExternalBackend(c *NetworkConfig) error {
+	unused, err := n.deleteBackends(c)
+	if err != nil || !unused {
+		return err
+	}
+
+	if err := n.deleteDNSRecord(c); err != nil {
+		return fmt.Errorf("error deleting DNS record: %s", err)
+	}
+
+	if err := n.deleteFirewalls(c); err != nil {
+		return err
+	}
+
+	for _, name := range n.forwardingRuleNames(c) {
+		if err := n.deleteForwardingRule(&compute.ForwardingRule{Name: name}); err != nil {
+			return err
+		}
+	}
+
+	if err := n.releaseAddress(c); err != nil {
+		return fmt.Errorf("error releasing static address: %s", err)
+	}
+
+	if err := n.deleteBackendService(c); err != nil {
+		return err
+	}
+
+	return n.deleteBackendHealthCheck(c)
+}
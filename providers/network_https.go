@@ -0,0 +1,410 @@
+package providers
+
+import (
+	"fmt"
+	"net"
+
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// createOrUpdateUrlMap creates the URL map of the group frontend or adds the
+// routes of the config to the existing one.
+func (n *Network) createOrUpdateUrlMap(c *NetworkConfig) (func() error, error) {
+	var previous *compute.UrlMap
+	err := n.updateUrlMap(c, func(old *compute.UrlMap) (*compute.UrlMap, bool) {
+		previous = old
+		new := c.UrlMap(n.project, n.instance, old)
+		return new, old == nil || isUrlMapOutdated(old, new)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	service := BackendServiceURL(n.project, c.Name(n.instance))
+	if previous == nil {
+		return func() error {
+			unused, err := n.removeUrlRoutes(c)
+			if err != nil || !unused {
+				return err
+			}
+
+			return n.deleteUrlMap(c)
+		}, nil
+	}
+
+	return func() error {
+		return n.updateUrlMap(c, func(current *compute.UrlMap) (*compute.UrlMap, bool) {
+			if current == nil {
+				return nil, false
+			}
+
+			new := restoreUrlRoutes(current, previous, service)
+			return new, isUrlMapOutdated(current, new)
+		})
+	}, nil
+}
+
+// removeUrlRoutes removes the routes of the config from the URL map of the
+// group, returning true when no other config is routed by the map, which is
+// then left as is to be deleted along with the frontend.
+func (n *Network) removeUrlRoutes(c *NetworkConfig) (bool, error) {
+	service := BackendServiceURL(n.project, c.Name(n.instance))
+	unused := false
+	err := n.updateUrlMap(c, func(old *compute.UrlMap) (*compute.UrlMap, bool) {
+		if old == nil {
+			unused = true
+			return nil, false
+		}
+
+		new := withoutUrlRoutes(old, service)
+		unused = new == nil
+		return new, !unused && isUrlMapOutdated(old, new)
+	})
+
+	return unused, err
+}
+
+// updateUrlMap inserts or patches the URL map of the group with the one
+// returned by update, if any change. The existing map is nil when not found.
+// The fingerprint of the map guards against concurrent modifications by the
+// other configs of the group, on conflict the update is retried.
+func (n *Network) updateUrlMap(c *NetworkConfig, update func(*compute.UrlMap) (*compute.UrlMap, bool)) error {
+	name := c.FrontendName(n.instance)
+	for retries := 0; ; retries++ {
+		old, err := n.s.UrlMaps.Get(n.project, name).Do()
+		if err != nil {
+			if !isNotFound(err) {
+				return err
+			}
+
+			old = nil
+		}
+
+		new, changed := update(old)
+		if !changed {
+			return nil
+		}
+
+		var op *compute.Operation
+		if old == nil {
+			op, err = n.s.UrlMaps.Insert(n.project, new).Do()
+		} else {
+			log15.Info("URL map outdated, patching", "url-map", name)
+			op, err = n.patchUrlMap(new, old.Fingerprint)
+		}
+
+		if err == nil {
+			return n.WaitDone(op)
+		}
+
+		if !isConflict(err) || retries >= MaxConflictRetries {
+			return err
+		}
+
+		log15.Warn("URL map modified concurrently, retrying", "url-map", name, "retries", retries)
+		conflictBackoff(retries)
+	}
+}
+
+func (n *Network) patchUrlMap(m *compute.UrlMap, fingerprint string) (*compute.Operation, error) {
+	return n.s.UrlMaps.Patch(n.project, m.Name, &compute.UrlMap{
+		DefaultService:  m.DefaultService,
+		HostRules:       m.HostRules,
+		PathMatchers:    m.PathMatchers,
+		Fingerprint:     fingerprint,
+		ForceSendFields: []string{"HostRules", "PathMatchers"},
+	}).Do()
+}
+
+// createSslCertificate creates the Google-managed certificate, unless an
+// existing certificate is given.
+func (n *Network) createSslCertificate(c *NetworkConfig) (func() error, error) {
+	cert := c.SslCertificate(n.instance)
+	if cert == nil {
+		return nil, nil
+	}
+
+	_, err := n.s.SslCertificates.Get(n.project, cert.Name).Do()
+	if err == nil || !isNotFound(err) {
+		return nil, err
+	}
+
+	op, err := n.s.SslCertificates.Insert(n.project, cert).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error { return n.deleteSslCertificate(c) }, n.WaitDone(op)
+}
+
+// createTargetHttpsProxy creates the proxy of the group frontend or adds the
+// certificate of the config to the existing one.
+func (n *Network) createTargetHttpsProxy(c *NetworkConfig) (func() error, error) {
+	proxy := c.TargetHttpsProxy(n.project, n.instance)
+	current, err := n.s.TargetHttpsProxies.Get(n.project, proxy.Name).Do()
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		op, err := n.s.TargetHttpsProxies.Insert(n.project, proxy).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteTargetHttpsProxy(c) }, n.WaitDone(op)
+	}
+
+	certificate := c.SslCertificateURL(n.project, n.instance)
+	if hasCertificate(current.SslCertificates, certificate) {
+		return nil, nil
+	}
+
+	log15.Info("adding SSL certificate to target HTTPS proxy", "proxy", proxy.Name, "certificate", ResourceName(certificate))
+	if err := n.setSslCertificates(proxy.Name, append(current.SslCertificates, certificate)); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := n.removeProxyCertificate(c, certificate)
+		return err
+	}, nil
+}
+
+// removeProxyCertificate removes the certificate from the proxy of the group,
+// returning false when it's kept as the last certificate of the proxy.
+func (n *Network) removeProxyCertificate(c *NetworkConfig, certificate string) (bool, error) {
+	name := c.FrontendName(n.instance)
+	proxy, err := n.s.TargetHttpsProxies.Get(n.project, name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	var remaining []string
+	for _, cert := range proxy.SslCertificates {
+		if ResourceName(cert) != ResourceName(certificate) {
+			remaining = append(remaining, cert)
+		}
+	}
+
+	if len(remaining) == len(proxy.SslCertificates) {
+		return true, nil
+	}
+
+	if len(remaining) == 0 {
+		log15.Warn("last SSL certificate of target HTTPS proxy, keeping it", "proxy", name, "certificate", ResourceName(certificate))
+		return false, nil
+	}
+
+	return true, n.setSslCertificates(name, remaining)
+}
+
+func (n *Network) setSslCertificates(proxy string, certificates []string) error {
+	op, err := n.s.TargetHttpsProxies.SetSslCertificates(n.project, proxy, &compute.TargetHttpsProxiesSetSslCertificatesRequest{
+		SslCertificates: certificates,
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func hasCertificate(certificates []string, certificate string) bool {
+	for _, cert := range certificates {
+		if ResourceName(cert) == ResourceName(certificate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createGlobalForwardingRules creates the forwarding rule of every IP version
+// of the config.
+func (n *Network) createGlobalForwardingRules(c *NetworkConfig) (func() error, error) {
+	var created []string
+	undo := func() error {
+		for _, name := range created {
+			op, err := n.s.GlobalForwardingRules.Delete(n.project, name).Do()
+			if err := n.waitDeleted(op, err); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, rule := range c.GlobalForwardingRules(n.project, n.instance) {
+		inserted, err := n.createGlobalForwardingRule(rule)
+		if inserted {
+			created = append(created, rule.Name)
+		}
+
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	if len(created) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+func (n *Network) createGlobalForwardingRule(rule *compute.ForwardingRule) (bool, error) {
+	if err := n.resolveGlobalForwardingRule(rule); err != nil {
+		return false, err
+	}
+
+	_, err := n.s.GlobalForwardingRules.Get(n.project, rule.Name).Do()
+	if err == nil || !isNotFound(err) {
+		return false, err
+	}
+
+	op, err := n.s.GlobalForwardingRules.Insert(n.project, rule).Do()
+	if err != nil {
+		return false, err
+	}
+
+	return true, n.WaitDone(op)
+}
+
+func (n *Network) resolveGlobalForwardingRule(rule *compute.ForwardingRule) error {
+	if rule.IPAddress == "" || net.ParseIP(rule.IPAddress) != nil {
+		return nil
+	}
+
+	addr, err := n.s.GlobalAddresses.Get(n.project, rule.IPAddress).Do()
+	if err != nil {
+		return err
+	}
+
+	rule.IPAddress = addr.Address
+	return nil
+}
+
+// deleteHTTPS removes the instance from the backends, the resources of the
+// config are deleted when no backend remains in the service, and the group
+// frontend when no other config is routed by its URL map.
+func (n *Network) deleteHTTPS(c *NetworkConfig) error {
+	unused, err := n.deleteBackends(c)
+	if err != nil || !unused {
+		return err
+	}
+
+	if err := n.deleteDNSRecord(c); err != nil {
+		return fmt.Errorf("error deleting DNS record: %s", err)
+	}
+
+	if err := n.deleteFirewalls(c); err != nil {
+		return err
+	}
+
+	if err := n.deleteFrontend(c); err != nil {
+		return err
+	}
+
+	if err := n.deleteBackendService(c); err != nil {
+		return err
+	}
+
+	if err := n.deleteSecurityPolicy(c); err != nil {
+		return err
+	}
+
+	return n.deleteBackendHealthCheck(c)
+}
+
+// deleteFrontend removes the routes and the managed certificate of the config
+// from the frontend of the group, deleting it when no other config remains.
+func (n *Network) deleteFrontend(c *NetworkConfig) error {
+	unused, err := n.removeUrlRoutes(c)
+	if err != nil {
+		return fmt.Errorf("error removing URL map routes: %s", err)
+	}
+
+	if !unused {
+		if c.SslCertificate(n.instance) == nil {
+			return nil
+		}
+
+		removed, err := n.removeProxyCertificate(c, c.SslCertificateURL(n.project, n.instance))
+		if err != nil || !removed {
+			return err
+		}
+
+		return n.deleteSslCertificate(c)
+	}
+
+	if err := n.deleteGlobalForwardingRules(c); err != nil {
+		return err
+	}
+
+	if err := n.releaseAddress(c); err != nil {
+		return fmt.Errorf("error releasing static address: %s", err)
+	}
+
+	if err := n.deleteTargetHttpsProxy(c); err != nil {
+		return err
+	}
+
+	if err := n.deleteSslCertificate(c); err != nil {
+		return err
+	}
+
+	return n.deleteUrlMap(c)
+}
+
+func (n *Network) deleteGlobalForwardingRules(c *NetworkConfig) error {
+	for _, rule := range c.GlobalForwardingRules(n.project, n.instance) {
+		op, err := n.s.GlobalForwardingRules.Delete(n.project, rule.Name).Do()
+		if err := n.waitDeleted(op, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *Network) deleteTargetHttpsProxy(c *NetworkConfig) error {
+	op, err := n.s.TargetHttpsProxies.Delete(n.project, c.FrontendName(n.instance)).Do()
+	return n.waitDeleted(op, err)
+}
+
+// deleteSslCertificate deletes the Google-managed certificate, the given
+// certificates are never deleted.
+func (n *Network) deleteSslCertificate(c *NetworkConfig) error {
+	if c.SslCertificate(n.instance) == nil {
+		return nil
+	}
+
+	op, err := n.s.SslCertificates.Delete(n.project, c.Name(n.instance)).Do()
+	return n.waitDeleted(op, err)
+}
+
+func (n *Network) deleteUrlMap(c *NetworkConfig) error {
+	op, err := n.s.UrlMaps.Delete(n.project, c.FrontendName(n.instance)).Do()
+	return n.waitDeleted(op, err)
+}
+
+// waitDeleted waits for a delete operation, a missing resource isn't an error.
+func (n *Network) waitDeleted(op *compute.Operation, err error) error {
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
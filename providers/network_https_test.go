@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+type HTTPSSuite struct{}
+
+var _ = Suite(&HTTPSSuite{})
+
+func (s *HTTPSSuite) TestIsUrlMapOutdated(c *C) {
+	config := &NetworkConfig{
+		Container: "bar",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com", "www.example.com"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+	}
+
+	old := config.UrlMap("qux", "foo", nil)
+	config.Hosts = []string{"www.example.com", "example.com"}
+	c.Assert(isUrlMapOutdated(old, config.UrlMap("qux", "foo", nil)), Equals, false)
+	c.Assert(isUrlMapOutdated(old, config.UrlMap("qux", "foo", old)), Equals, false)
+
+	config.Paths = []string{"/api/*"}
+	c.Assert(isUrlMapOutdated(old, config.UrlMap("qux", "foo", old)), Equals, true)
+}
+
+func (s *HTTPSSuite) TestUrlMapGroup(c *C) {
+	api := &NetworkConfig{
+		Container: "api",
+		GroupName: "web",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Paths:     []string{"/api/*"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+	}
+
+	site := &NetworkConfig{
+		Container: "site",
+		GroupName: "web",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Paths:     []string{"/web/*"},
+		Ports:     []docker.Port{docker.Port("8081/tcp")},
+	}
+
+	apiService := BackendServiceURL("qux", api.Name("foo"))
+	siteService := BackendServiceURL("qux", site.Name("foo"))
+	c.Assert(apiService, Not(Equals), siteService)
+	c.Assert(api.FrontendName("foo"), Equals, site.FrontendName("foo"))
+
+	m := api.UrlMap("qux", "foo", nil)
+	m = site.UrlMap("qux", "foo", m)
+	c.Assert(resolveUrlMap(m, "example.com", "/api/users"), Equals, apiService)
+	c.Assert(resolveUrlMap(m, "example.com", "/web/index.html"), Equals, siteService)
+	c.Assert(resolveUrlMap(m, "other.com", "/web/index.html"), Equals, apiService)
+	c.Assert(isUrlMapOutdated(m, site.UrlMap("qux", "foo", m)), Equals, false)
+
+	// the hosts of a config without paths keep routing to it
+	docs := &NetworkConfig{
+		Container: "docs",
+		GroupName: "web",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"docs.example.com"},
+		Ports:     []docker.Port{docker.Port("8083/tcp")},
+	}
+
+	docsService := BackendServiceURL("qux", docs.Name("foo"))
+	m = docs.UrlMap("qux", "foo", m)
+	c.Assert(resolveUrlMap(m, "docs.example.com", "/api/users"), Equals, docsService)
+
+	// the config without hosts nor paths becomes the default of the group
+	fallback := &NetworkConfig{
+		Container:   "fallback",
+		GroupName:   "web",
+		Scheme:      SchemeHTTPS,
+		Certificate: "existing",
+		Ports:       []docker.Port{docker.Port("8082/tcp")},
+	}
+
+	fallbackService := BackendServiceURL("qux", fallback.Name("foo"))
+	m = fallback.UrlMap("qux", "foo", m)
+	c.Assert(resolveUrlMap(m, "other.com", "/"), Equals, fallbackService)
+	c.Assert(resolveUrlMap(m, "example.com", "/"), Equals, fallbackService)
+	c.Assert(resolveUrlMap(m, "example.com", "/api/users"), Equals, apiService)
+
+	m = withoutUrlRoutes(m, apiService)
+	c.Assert(resolveUrlMap(m, "example.com", "/api/users"), Equals, fallbackService)
+	c.Assert(resolveUrlMap(m, "example.com", "/web/index.html"), Equals, siteService)
+
+	c.Assert(resolveUrlMap(m, "docs.example.com", "/"), Equals, docsService)
+
+	m = withoutUrlRoutes(m, fallbackService)
+	c.Assert(resolveUrlMap(m, "docs.example.com", "/"), Equals, docsService)
+	m = withoutUrlRoutes(m, docsService)
+	c.Assert(resolveUrlMap(m, "other.com", "/"), Equals, siteService)
+	c.Assert(withoutUrlRoutes(m, siteService), IsNil)
+}
+
+func (s *HTTPSSuite) TestRestoreUrlRoutes(c *C) {
+	config := &NetworkConfig{
+		Container: "api",
+		GroupName: "web",
+		Scheme:    SchemeHTTPS,
+		Hosts:     []string{"example.com"},
+		Paths:     []string{"/api/*"},
+		Ports:     []docker.Port{docker.Port("8080/tcp")},
+	}
+
+	previous := config.UrlMap("qux", "foo", nil)
+	config.Paths = []string{"/v2/*"}
+	current := config.UrlMap("qux", "foo", previous)
+
+	service := BackendServiceURL("qux", config.Name("foo"))
+	restored := restoreUrlRoutes(current, previous, service)
+	c.Assert(isUrlMapOutdated(previous, restored), Equals, false)
+}
+
+// resolveUrlMap returns the backend service of the host and path, matching
+// the exact host before the wildcard one and the longest path, as the load
+// balancer does.
+func resolveUrlMap(m *compute.UrlMap, host, path string) string {
+	matcher := ""
+	for _, h := range m.HostRules {
+		for _, pattern := range h.Hosts {
+			if pattern == host || (pattern == "*" && matcher == "") {
+				matcher = h.PathMatcher
+			}
+		}
+	}
+
+	for _, pm := range m.PathMatchers {
+		if pm.Name != matcher {
+			continue
+		}
+
+		service, longest := pm.DefaultService, -1
+		for _, rule := range pm.PathRules {
+			for _, pattern := range rule.Paths {
+				prefix := strings.TrimSuffix(pattern, "*")
+				matched := pattern == path || (prefix != pattern && strings.HasPrefix(path, prefix))
+				if matched && len(pattern) > longest {
+					service, longest = rule.Service, len(pattern)
+				}
+			}
+		}
+
+		return service
+	}
+
+	return m.DefaultService
+}
@@ -0,0 +1,46 @@
+package providers
+
+import (
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func (n *Network) createInternalForwardingRule(c *NetworkConfig) (func() error, error) {
+	serviceURL := RegionBackendServiceURL(n.project, n.region, c.Name(n.instance))
+	rule := c.InternalForwardingRule(n.instance, n.region, serviceURL)
+
+	created, err := n.createForwardingRule(rule)
+	if !created {
+		return nil, err
+	}
+
+	return func() error { return n.deleteForwardingRule(rule) }, err
+}
+
+// deleteInternal removes the instance from the backends, the resources shared
+// by the load balancer are deleted when no backend remains in the service.
+func (n *Network) deleteInternal(c *NetworkConfig) error {
+	unused, err := n.deleteBackends(c)
+	if err != nil || !unused {
+		return err
+	}
+
+	if err := n.deleteDNSRecord(c); err != nil {
+		return fmt.Errorf("error deleting DNS record: %s", err)
+	}
+
+	if err := n.deleteFirewalls(c); err != nil {
+		return err
+	}
+
+	if err := n.deleteForwardingRule(&compute.ForwardingRule{Name: c.Name(n.instance)}); err != nil {
+		return err
+	}
+
+	if err := n.deleteBackendService(c); err != nil {
+		return err
+	}
+
+	return n.deleteBackendHealthCheck(c)
+}
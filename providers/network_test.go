@@ -2,6 +2,7 @@ package providers
 
 import (
 	"github.com/fsouza/go-dockerclient"
+	"google.golang.org/api/compute/v1"
 	. "gopkg.in/check.v1"
 )
 
@@ -34,3 +35,50 @@ func (s *NetworkSuite) TestCreate(c *C) {
 	err = n.Delete(config)
 	c.Assert(err, IsNil)
 }
+
+type NetworkTagsSuite struct{}
+
+var _ = Suite(&NetworkTagsSuite{})
+
+func (s *NetworkTagsSuite) TestPruneTags(c *C) {
+	firewalls := map[string]bool{"docker-network-bar-1": true}
+
+	tags, changed := pruneTags([]string{
+		"http-server", "docker-network-foo-1", "docker-network-bar-1", "docker-network-qux-1",
+	}, "docker-network-foo-1", firewalls)
+	c.Assert(changed, Equals, true)
+	c.Assert(tags, DeepEquals, []string{"http-server", "docker-network-bar-1"})
+
+	tags, changed = pruneTags([]string{"http-server", "docker-network-bar-1"}, "docker-network-foo-1", firewalls)
+	c.Assert(changed, Equals, false)
+	c.Assert(tags, DeepEquals, []string{"http-server", "docker-network-bar-1"})
+}
+
+func (s *NetworkTagsSuite) TestRemoveTag(c *C) {
+	tags, changed := removeTag([]string{"http-server", "docker-network-foo-1", "docker-network-qux-1"}, "docker-network-foo-1")
+	c.Assert(changed, Equals, true)
+	c.Assert(tags, DeepEquals, []string{"http-server", "docker-network-qux-1"})
+
+	_, changed = removeTag([]string{"http-server"}, "docker-network-foo-1")
+	c.Assert(changed, Equals, false)
+}
+
+func (s *NetworkTagsSuite) TestFirewallProjects(c *C) {
+	n := &Network{
+		Client:  Client{project: "service"},
+		network: "https://www.googleapis.com/compute/v1/projects/host/global/networks/shared",
+	}
+
+	c.Assert(n.firewallProjects(), DeepEquals, []string{"host", "service"})
+
+	n.addFirewallProject("other")
+	config := &NetworkConfig{Network: "projects/third/global/networks/vpc"}
+	c.Assert(n.firewallProjects(config), DeepEquals, []string{"host", "other", "service", "third"})
+
+	mine := &NetworkConfig{Container: "foo", Project: "service"}
+	theirs := &NetworkConfig{Container: "foo", Project: "another"}
+	c.Assert(n.ownsFirewall("service", &compute.Firewall{}), Equals, true)
+	c.Assert(n.ownsFirewall("host", &compute.Firewall{Description: mine.Description()}), Equals, true)
+	c.Assert(n.ownsFirewall("host", &compute.Firewall{Description: theirs.Description()}), Equals, false)
+	c.Assert(n.ownsFirewall("host", &compute.Firewall{}), Equals, false)
+}
@@ -0,0 +1,398 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Resource kinds of the load balancers created by gce-docker.
+const (
+	KindForwardingRule    = "forwarding-rule"
+	KindTargetPool        = "target-pool"
+	KindFirewall          = "firewall"
+	KindHttpHealthCheck   = "http-health-check"
+	KindBackendService    = "backend-service"
+	KindInstanceGroup     = "instance-group"
+	KindHealthCheck       = "health-check"
+	KindRegionHealthCheck = "region-health-check"
+
+	KindGlobalForwardingRule = "global-forwarding-rule"
+	KindTargetHttpsProxy     = "target-https-proxy"
+	KindUrlMap               = "url-map"
+	KindGlobalBackendService = "global-backend-service"
+	KindSslCertificate       = "ssl-certificate"
+	KindSecurityPolicy       = "security-policy"
+	KindAddress              = "address"
+	KindGlobalAddress        = "global-address"
+)
+
+// Resource identifies a load balancer resource to be deleted.
+type Resource struct {
+	Kind              string
+	Name              string
+	CreationTimestamp string
+	// Zone of the zonal resources, as the instance groups.
+	Zone string
+	// Project of the resource, when it isn't the one of the instance, as the
+	// firewalls of a shared VPC.
+	Project string
+}
+
+// NetworkResources holds the load balancer resources created by gce-docker in
+// the project and region, along with the tags of every instance of the project.
+// The target pools and the backend services of other regions are only kept to
+// know which of the global health checks and the instance groups are still in
+// use. The global resources belong to the https load balancers, the addresses
+// are the ones reserved by gce-docker to be released.
+type NetworkResources struct {
+	TargetPools           []*compute.TargetPool
+	RemoteTargetPools     []*compute.TargetPool
+	ForwardingRules       []*compute.ForwardingRule
+	Firewalls             []*compute.Firewall
+	HttpHealthChecks      []*compute.HttpHealthCheck
+	BackendServices       []*compute.BackendService
+	RemoteBackendServices []*compute.BackendService
+	InstanceGroups        []*compute.InstanceGroup
+	HealthChecks          []*compute.HealthCheck
+	RegionHealthChecks    []*compute.HealthCheck
+	GlobalForwardingRules []*compute.ForwardingRule
+	TargetHttpsProxies    []*compute.TargetHttpsProxy
+	UrlMaps               []*compute.UrlMap
+	GlobalBackendServices []*compute.BackendService
+	SslCertificates       []*compute.SslCertificate
+	SecurityPolicies      []*compute.SecurityPolicy
+	Addresses             []*compute.Address
+	GlobalAddresses       []*compute.Address
+	Instances             map[string][]string
+}
+
+// Resources lists the load balancer resources created by gce-docker.
+func (n *Network) Resources() (*NetworkResources, error) {
+	r := &NetworkResources{Instances: make(map[string][]string, 0)}
+	filter := fmt.Sprintf("name eq %s.*", NetworkPrefix)
+	ctx := context.Background()
+
+	err := n.s.TargetPools.AggregatedList(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.TargetPoolAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, p := range scoped.TargetPools {
+					if ResourceName(p.Region) == n.region {
+						r.TargetPools = append(r.TargetPools, p)
+						continue
+					}
+
+					r.RemoteTargetPools = append(r.RemoteTargetPools, p)
+				}
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing target pools: %s", err)
+	}
+
+	err = n.s.ForwardingRules.List(n.project, n.region).Filter(filter).
+		Pages(ctx, func(l *compute.ForwardingRuleList) error {
+			r.ForwardingRules = append(r.ForwardingRules, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing forwarding rules: %s", err)
+	}
+
+	for _, project := range n.firewallProjects() {
+		err = n.s.Firewalls.List(project).Filter(filter).
+			Pages(ctx, func(l *compute.FirewallList) error {
+				for _, f := range l.Items {
+					if n.ownsFirewall(project, f) {
+						r.Firewalls = append(r.Firewalls, f)
+					}
+				}
+
+				return nil
+			})
+
+		if err != nil {
+			return nil, fmt.Errorf("error listing firewalls: %s", err)
+		}
+	}
+
+	err = n.s.HttpHealthChecks.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.HttpHealthCheckList) error {
+			r.HttpHealthChecks = append(r.HttpHealthChecks, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing health checks: %s", err)
+	}
+
+	err = n.s.BackendServices.AggregatedList(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.BackendServiceAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, b := range scoped.BackendServices {
+					switch {
+					case b.Region == "":
+						r.GlobalBackendServices = append(r.GlobalBackendServices, b)
+					case ResourceName(b.Region) == n.region:
+						r.BackendServices = append(r.BackendServices, b)
+					default:
+						r.RemoteBackendServices = append(r.RemoteBackendServices, b)
+					}
+				}
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing backend services: %s", err)
+	}
+
+	err = n.s.InstanceGroups.AggregatedList(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.InstanceGroupAggregatedList) error {
+			for _, scoped := range l.Items {
+				r.InstanceGroups = append(r.InstanceGroups, scoped.InstanceGroups...)
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing instance groups: %s", err)
+	}
+
+	err = n.s.HealthChecks.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.HealthCheckList) error {
+			r.HealthChecks = append(r.HealthChecks, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing backend health checks: %s", err)
+	}
+
+	err = n.s.RegionHealthChecks.List(n.project, n.region).Filter(filter).
+		Pages(ctx, func(l *compute.HealthCheckList) error {
+			r.RegionHealthChecks = append(r.RegionHealthChecks, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing regional health checks: %s", err)
+	}
+
+	if err := n.globalResources(r, filter); err != nil {
+		return nil, err
+	}
+
+	if err := n.releasableAddresses(r); err != nil {
+		return nil, err
+	}
+
+	err = n.s.Instances.AggregatedList(n.project).
+		Pages(ctx, func(l *compute.InstanceAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, i := range scoped.Instances {
+					var tags []string
+					if i.Tags != nil {
+						tags = i.Tags.Items
+					}
+
+					r.Instances[i.SelfLink] = tags
+				}
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing instances: %s", err)
+	}
+
+	return r, nil
+}
+
+// globalResources lists the global resources of the https load balancers,
+// besides the backend services.
+func (n *Network) globalResources(r *NetworkResources, filter string) error {
+	ctx := context.Background()
+	err := n.s.GlobalForwardingRules.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.ForwardingRuleList) error {
+			r.GlobalForwardingRules = append(r.GlobalForwardingRules, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing global forwarding rules: %s", err)
+	}
+
+	err = n.s.TargetHttpsProxies.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.TargetHttpsProxyList) error {
+			r.TargetHttpsProxies = append(r.TargetHttpsProxies, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing target https proxies: %s", err)
+	}
+
+	err = n.s.UrlMaps.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.UrlMapList) error {
+			r.UrlMaps = append(r.UrlMaps, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing URL maps: %s", err)
+	}
+
+	err = n.s.SslCertificates.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.SslCertificateList) error {
+			r.SslCertificates = append(r.SslCertificates, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing SSL certificates: %s", err)
+	}
+
+	err = n.s.SecurityPolicies.List(n.project).Filter(filter).
+		Pages(ctx, func(l *compute.SecurityPolicyList) error {
+			r.SecurityPolicies = append(r.SecurityPolicies, l.Items...)
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing security policies: %s", err)
+	}
+
+	return nil
+}
+
+// releasableAddresses lists the static addresses reserved by gce-docker for
+// the load balancers that release them, the rest are kept even if unused.
+func (n *Network) releasableAddresses(r *NetworkResources) error {
+	releasable := func(a *compute.Address) bool {
+		return a.Labels[LabelManaged] == "true" && a.Labels[LabelRelease] == "true"
+	}
+
+	ctx := context.Background()
+	err := n.s.Addresses.List(n.project, n.region).
+		Pages(ctx, func(l *compute.AddressList) error {
+			for _, a := range l.Items {
+				if releasable(a) {
+					r.Addresses = append(r.Addresses, a)
+				}
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing addresses: %s", err)
+	}
+
+	err = n.s.GlobalAddresses.List(n.project).
+		Pages(ctx, func(l *compute.AddressList) error {
+			for _, a := range l.Items {
+				if releasable(a) {
+					r.GlobalAddresses = append(r.GlobalAddresses, a)
+				}
+			}
+
+			return nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing global addresses: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteResource deletes the given resource, missing resources are ignored.
+func (n *Network) DeleteResource(r Resource) error {
+	var op *compute.Operation
+	var err error
+
+	switch r.Kind {
+	case KindForwardingRule:
+		op, err = n.s.ForwardingRules.Delete(n.project, n.region, r.Name).Do()
+	case KindTargetPool:
+		op, err = n.s.TargetPools.Delete(n.project, n.region, r.Name).Do()
+	case KindFirewall:
+		project := r.Project
+		if project == "" {
+			project = n.project
+		}
+
+		op, err = n.s.Firewalls.Delete(project, r.Name).Do()
+	case KindHttpHealthCheck:
+		op, err = n.s.HttpHealthChecks.Delete(n.project, r.Name).Do()
+	case KindBackendService:
+		op, err = n.s.RegionBackendServices.Delete(n.project, n.region, r.Name).Do()
+	case KindInstanceGroup:
+		op, err = n.s.InstanceGroups.Delete(n.project, r.Zone, r.Name).Do()
+	case KindHealthCheck:
+		op, err = n.s.HealthChecks.Delete(n.project, r.Name).Do()
+	case KindRegionHealthCheck:
+		op, err = n.s.RegionHealthChecks.Delete(n.project, n.region, r.Name).Do()
+	case KindGlobalForwardingRule:
+		op, err = n.s.GlobalForwardingRules.Delete(n.project, r.Name).Do()
+	case KindTargetHttpsProxy:
+		op, err = n.s.TargetHttpsProxies.Delete(n.project, r.Name).Do()
+	case KindUrlMap:
+		op, err = n.s.UrlMaps.Delete(n.project, r.Name).Do()
+	case KindGlobalBackendService:
+		op, err = n.s.BackendServices.Delete(n.project, r.Name).Do()
+	case KindSslCertificate:
+		op, err = n.s.SslCertificates.Delete(n.project, r.Name).Do()
+	case KindSecurityPolicy:
+		op, err = n.s.SecurityPolicies.Delete(n.project, r.Name).Do()
+	case KindAddress:
+		op, err = n.s.Addresses.Delete(n.project, n.region, r.Name).Do()
+	case KindGlobalAddress:
+		op, err = n.s.GlobalAddresses.Delete(n.project, r.Name).Do()
+	default:
+		return fmt.Errorf("unknown resource kind %q", r.Kind)
+	}
+
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+// ownsFirewall returns if the rule belongs to a load balancer of the project,
+// the host project of a shared VPC holds the rules of every service project,
+// whose instances are unknown.
+func (n *Network) ownsFirewall(project string, f *compute.Firewall) bool {
+	if project == n.project {
+		return true
+	}
+
+	c, err := DecodeNetworkConfig(f.Description)
+	return err == nil && c.Project == n.project
+}
+
+// ResourceProject returns the project of a resource given its URL.
+func ResourceProject(url string) string {
+	return projectFromURL(url)
+}
+
+// ResourceName returns the name of a resource given its URL.
+func ResourceName(url string) string {
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
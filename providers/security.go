@@ -0,0 +1,238 @@
+package providers
+
+import (
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// attachSecurityPolicy attaches the Cloud Armor policy to the backend service
+// of the https load balancer, the managed policy is created, or its rules
+// updated, before. The managed policy no longer in the config is detached and
+// deleted.
+func (n *Network) attachSecurityPolicy(c *NetworkConfig) (func() error, error) {
+	url := c.SecurityPolicyURL(n.project, n.instance)
+	var undos []func() error
+	undo := func() error {
+		for i := len(undos) - 1; i >= 0; i-- {
+			if err := undos[i](); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if c.ManagedSecurityPolicy(n.instance) != nil {
+		u, err := n.createOrUpdateSecurityPolicy(c)
+		if u != nil {
+			undos = append(undos, u)
+		}
+
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	service, err := n.s.BackendServices.Get(n.project, c.Name(n.instance)).Do()
+	if err != nil {
+		return undo, err
+	}
+
+	previous := service.SecurityPolicy
+	if ResourceName(previous) != ResourceName(url) {
+		if err := n.setSecurityPolicy(c, url); err != nil {
+			return undo, err
+		}
+
+		log15.Info("security policy attached", "backend-service", service.Name, "security-policy", ResourceName(url))
+		undos = append(undos, func() error { return n.setSecurityPolicy(c, previous) })
+
+		if c.ManagedSecurityPolicy(n.instance) == nil && ResourceName(previous) == c.Name(n.instance) {
+			u, err := n.removeManagedSecurityPolicy(c)
+			if u != nil {
+				undos = append(undos, u)
+			}
+
+			if err != nil {
+				return undo, err
+			}
+		}
+	}
+
+	if len(undos) == 0 {
+		return nil, nil
+	}
+
+	return undo, nil
+}
+
+// setSecurityPolicy sets the policy of the backend service, an empty URL
+// detaches the current one.
+func (n *Network) setSecurityPolicy(c *NetworkConfig, url string) error {
+	op, err := n.s.BackendServices.SetSecurityPolicy(n.project, c.Name(n.instance), &compute.SecurityPolicyReference{
+		SecurityPolicy:  url,
+		ForceSendFields: []string{"SecurityPolicy"},
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	return n.WaitDone(op)
+}
+
+func (n *Network) createOrUpdateSecurityPolicy(c *NetworkConfig) (func() error, error) {
+	new := c.ManagedSecurityPolicy(n.instance)
+	old, err := n.s.SecurityPolicies.Get(n.project, new.Name).Do()
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		op, err := n.s.SecurityPolicies.Insert(n.project, new).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return n.deleteSecurityPolicy(c) }, n.WaitDone(op)
+	}
+
+	if !isSecurityPolicyOutdated(old, new) {
+		return nil, nil
+	}
+
+	log15.Info("security policy outdated, replacing rules", "security-policy", new.Name)
+	if err := n.replaceSecurityRules(old, new); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		current, err := n.s.SecurityPolicies.Get(n.project, old.Name).Do()
+		if err != nil {
+			return err
+		}
+
+		return n.replaceSecurityRules(current, old)
+	}, nil
+}
+
+// replaceSecurityRules replaces the rules of the policy, but the default one
+// which can't be removed. The new rules are added before removing the old
+// ones, so the allowed traffic is never denied in between.
+func (n *Network) replaceSecurityRules(old, new *compute.SecurityPolicy) error {
+	for _, r := range unusedPriorityRules(old, new) {
+		op, err := n.s.SecurityPolicies.AddRule(n.project, old.Name, r).Do()
+		if err != nil {
+			return err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range old.Rules {
+		if r.Priority == DefaultSecurityRulePriority {
+			continue
+		}
+
+		op, err := n.s.SecurityPolicies.RemoveRule(n.project, old.Name).Priority(r.Priority).Do()
+		if err != nil {
+			return err
+		}
+
+		if err := n.WaitDone(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unusedPriorityRules returns the rules of the new policy, but the default
+// one, numbered from SecurityRulePriority skipping the priorities of the old
+// policy.
+func unusedPriorityRules(old, new *compute.SecurityPolicy) []*compute.SecurityPolicyRule {
+	used := make(map[int64]bool, 0)
+	for _, r := range old.Rules {
+		used[r.Priority] = true
+	}
+
+	var rules []*compute.SecurityPolicyRule
+	priority := SecurityRulePriority
+	for _, r := range new.Rules {
+		if r.Priority == DefaultSecurityRulePriority {
+			continue
+		}
+
+		for used[priority] {
+			priority++
+		}
+
+		rule := *r
+		rule.Priority = priority
+		used[priority] = true
+		rules = append(rules, &rule)
+	}
+
+	return rules
+}
+
+// isSecurityPolicyOutdated compares the ranges of the allow rules.
+func isSecurityPolicyOutdated(old, new *compute.SecurityPolicy) bool {
+	return !equalSet(securityAllowed(old), securityAllowed(new))
+}
+
+func securityAllowed(p *compute.SecurityPolicy) []string {
+	var ranges []string
+	for _, r := range p.Rules {
+		if r.Action != "allow" || r.Match == nil || r.Match.Config == nil {
+			continue
+		}
+
+		ranges = append(ranges, r.Match.Config.SrcIpRanges...)
+	}
+
+	return ranges
+}
+
+// removeManagedSecurityPolicy deletes the managed policy, already detached,
+// of a config without allowed ranges anymore. The policy is inserted again on
+// undo.
+func (n *Network) removeManagedSecurityPolicy(c *NetworkConfig) (func() error, error) {
+	old, err := n.s.SecurityPolicies.Get(n.project, c.Name(n.instance)).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if err := n.deleteSecurityPolicy(c); err != nil {
+		return nil, err
+	}
+
+	log15.Info("security policy deleted", "security-policy", old.Name)
+	return func() error {
+		op, err := n.s.SecurityPolicies.Insert(n.project, &compute.SecurityPolicy{
+			Name:        old.Name,
+			Description: old.Description,
+			Rules:       old.Rules,
+		}).Do()
+
+		if err != nil {
+			return err
+		}
+
+		return n.WaitDone(op)
+	}, nil
+}
+
+// deleteSecurityPolicy deletes the managed policy, named as the load balancer,
+// even if the config has no allowed ranges anymore. The given policies are
+// never deleted. The policy should be detached before.
+func (n *Network) deleteSecurityPolicy(c *NetworkConfig) error {
+	op, err := n.s.SecurityPolicies.Delete(n.project, c.Name(n.instance)).Do()
+	return n.waitDeleted(op, err)
+}
@@ -0,0 +1,52 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type SecuritySuite struct{}
+
+var _ = Suite(&SecuritySuite{})
+
+func (s *SecuritySuite) TestIsSecurityPolicyOutdated(c *C) {
+	config := &NetworkConfig{
+		Container:     "bar",
+		Scheme:        SchemeHTTPS,
+		Ports:         []docker.Port{docker.Port("8080/tcp")},
+		SecurityAllow: []string{"192.0.2.0/24", "198.51.100.0/24"},
+	}
+
+	old := config.ManagedSecurityPolicy("foo")
+	config.SecurityAllow = []string{"198.51.100.0/24", "192.0.2.0/24"}
+	c.Assert(isSecurityPolicyOutdated(old, config.ManagedSecurityPolicy("foo")), Equals, false)
+
+	config.SecurityAllow = []string{"192.0.2.0/24"}
+	c.Assert(isSecurityPolicyOutdated(old, config.ManagedSecurityPolicy("foo")), Equals, true)
+}
+
+func (s *SecuritySuite) TestUnusedPriorityRules(c *C) {
+	config := &NetworkConfig{
+		Container:     "bar",
+		Scheme:        SchemeHTTPS,
+		Ports:         []docker.Port{docker.Port("8080/tcp")},
+		SecurityAllow: []string{"192.0.2.0/24"},
+	}
+
+	old := config.ManagedSecurityPolicy("foo")
+	old.Rules = append(old.Rules, securityRule("allow", SecurityRulePriority+2, []string{"203.0.113.0/24"}))
+
+	config.SecurityAllow = make([]string, MaxSecurityRuleRanges+1)
+	for i := range config.SecurityAllow {
+		config.SecurityAllow[i] = fmt.Sprintf("10.0.%d.0/24", i)
+	}
+
+	rules := unusedPriorityRules(old, config.ManagedSecurityPolicy("foo"))
+	c.Assert(rules, HasLen, 2)
+	c.Assert(rules[0].Priority, Equals, SecurityRulePriority+1)
+	c.Assert(rules[0].Match.Config.SrcIpRanges, HasLen, MaxSecurityRuleRanges)
+	c.Assert(rules[1].Priority, Equals, SecurityRulePriority+3)
+	c.Assert(rules[1].Match.Config.SrcIpRanges, DeepEquals, []string{"10.0.10.0/24"})
+}
@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/storage/v1"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Storage struct {
+	s *storage.Service
+}
+
+func NewStorage(c *http.Client) (*Storage, error) {
+	s, err := storage.New(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Storage{s: s}, nil
+}
+
+// Upload streams r to the object at url, the CRC32C checksum of the content
+// is checked against the one computed by Cloud Storage.
+func (s *Storage) Upload(url string, r io.Reader) (*storage.Object, error) {
+	bucket, name, err := ParseStorageURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	h := crc32.New(crc32cTable)
+	o, err := s.s.Objects.Insert(bucket, &storage.Object{Name: name}).
+		Media(io.TeeReader(r, h)).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := encodeCRC32C(h); sum != o.Crc32c {
+		return nil, fmt.Errorf("checksum mismatch uploading %q, expected %s got %s", url, sum, o.Crc32c)
+	}
+
+	return o, nil
+}
+
+// Download returns a reader of the object at url, the reader returns an error
+// at EOF if the CRC32C checksum of the content doesn't match.
+func (s *Storage) Download(url string) (io.ReadCloser, *storage.Object, error) {
+	bucket, name, err := ParseStorageURL(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, err := s.s.Objects.Get(bucket, name).Do()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := s.s.Objects.Get(bucket, name).Generation(o.Generation).Download()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newChecksumReader(resp.Body, o.Crc32c), o, nil
+}
+
+// ParseStorageURL returns the bucket and object name from a gs:// URL.
+func ParseStorageURL(url string) (bucket, object string, err error) {
+	if !strings.HasPrefix(url, "gs://") {
+		return "", "", fmt.Errorf("invalid storage URL %q, must start with gs://", url)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(url, "gs://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid storage URL %q, must be gs://<bucket>/<object>", url)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+type checksumReader struct {
+	io.ReadCloser
+	h        hash.Hash32
+	expected string
+}
+
+func newChecksumReader(r io.ReadCloser, expected string) *checksumReader {
+	return &checksumReader{
+		ReadCloser: r,
+		h:          crc32.New(crc32cTable),
+		expected:   expected,
+	}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+
+	if sum := encodeCRC32C(r.h); sum != r.expected {
+		return n, fmt.Errorf("checksum mismatch, expected %s got %s", r.expected, sum)
+	}
+
+	return n, err
+}
+
+func encodeCRC32C(h hash.Hash32) string {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, h.Sum32())
+	return base64.StdEncoding.EncodeToString(sum)
+}
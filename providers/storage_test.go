@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type StorageSuite struct{}
+
+var _ = Suite(&StorageSuite{})
+
+func (s *StorageSuite) TestParseStorageURL(c *C) {
+	bucket, object, err := ParseStorageURL("gs://foo/bar/baz.tar.gz")
+	c.Assert(err, IsNil)
+	c.Assert(bucket, Equals, "foo")
+	c.Assert(object, Equals, "bar/baz.tar.gz")
+
+	_, _, err = ParseStorageURL("s3://foo/bar")
+	c.Assert(err, NotNil)
+
+	_, _, err = ParseStorageURL("gs://foo")
+	c.Assert(err, NotNil)
+
+	_, _, err = ParseStorageURL("gs://foo/")
+	c.Assert(err, NotNil)
+}
+
+func (s *StorageSuite) TestChecksumReader(c *C) {
+	// CRC32C of "hello world" as returned by Cloud Storage
+	r := newChecksumReader(ioutil.NopCloser(bytes.NewBufferString("hello world")), "yZRlqg==")
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "hello world")
+
+	r = newChecksumReader(ioutil.NopCloser(bytes.NewBufferString("hello world")), "AAAAAA==")
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, ErrorMatches, "checksum mismatch.*")
+}
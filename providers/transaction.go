@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// transaction records the undo function of every completed step, so the
+// changes can be rolled back in reverse order when a later step fails.
+type transaction struct {
+	steps []*step
+}
+
+type step struct {
+	name string
+	undo func() error
+}
+
+// Do runs a step, the undo function returned by the step, if any, is recorded
+// even on error, since a failed step may leave some changes behind. The error
+// is wrapped with the name of the step.
+func (t *transaction) Do(name string, do func() (func() error, error)) error {
+	undo, err := do()
+	if undo != nil {
+		t.steps = append(t.steps, &step{name: name, undo: undo})
+	}
+
+	if err != nil {
+		return fmt.Errorf("error %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// Rollback undoes the recorded steps in reverse order, the rollback goes on
+// with the remaining steps on error. The given error, the one that caused the
+// rollback, is returned along with the steps that couldn't be undone.
+func (t *transaction) Rollback(cause error) error {
+	var failed []string
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		s := t.steps[i]
+		if err := s.undo(); err != nil {
+			log15.Error("error rolling back", "step", s.name, "error", err)
+			failed = append(failed, fmt.Sprintf("%s: %s", s.name, err))
+			continue
+		}
+
+		log15.Info("step rolled back", "step", s.name)
+	}
+
+	t.steps = nil
+	if len(failed) == 0 {
+		return cause
+	}
+
+	return fmt.Errorf("%s (rollback failed: %s)", cause, strings.Join(failed, "; "))
+}
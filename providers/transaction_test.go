@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type TransactionSuite struct{}
+
+var _ = Suite(&TransactionSuite{})
+
+func (s *TransactionSuite) TestRollback(c *C) {
+	var undone []string
+	undo := func(name string) func() error {
+		return func() error {
+			undone = append(undone, name)
+			return nil
+		}
+	}
+
+	t := &transaction{}
+	err := t.Do("creating foo", func() (func() error, error) { return undo("foo"), nil })
+	c.Assert(err, IsNil)
+
+	err = t.Do("checking bar", func() (func() error, error) { return nil, nil })
+	c.Assert(err, IsNil)
+
+	err = t.Do("creating qux", func() (func() error, error) {
+		return undo("qux"), fmt.Errorf("quota exceeded")
+	})
+	c.Assert(err, ErrorMatches, "error creating qux: quota exceeded")
+
+	c.Assert(t.Rollback(err), Equals, err)
+	c.Assert(undone, DeepEquals, []string{"qux", "foo"})
+
+	c.Assert(t.Rollback(err), Equals, err)
+	c.Assert(undone, HasLen, 2)
+}
+
+func (s *TransactionSuite) TestRollbackError(c *C) {
+	t := &transaction{}
+	err := t.Do("creating foo", func() (func() error, error) {
+		return func() error { return fmt.Errorf("permission denied") }, nil
+	})
+	c.Assert(err, IsNil)
+
+	err = t.Do("creating bar", func() (func() error, error) { return nil, fmt.Errorf("quota exceeded") })
+	c.Assert(t.Rollback(err), ErrorMatches,
+		`error creating bar: quota exceeded \(rollback failed: creating foo: permission denied\)`)
+}
@@ -0,0 +1,215 @@
+package providers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// urlRoute routes a host and path to a backend service.
+type urlRoute struct {
+	Host    string
+	Path    string
+	Service string
+}
+
+func (r urlRoute) key() string {
+	return r.Host + r.Path
+}
+
+// UrlMap returns the URL map of the group, shared by the https load balancers
+// of its containers, with the routes of the config to its backend service
+// added to the ones of the existing map, if any. The config without hosts nor
+// paths is the default backend of the group, otherwise the default of the map
+// is kept, being the config creating the map until then.
+func (c *NetworkConfig) UrlMap(project, instance string, old *compute.UrlMap) *compute.UrlMap {
+	service := BackendServiceURL(project, c.Name(instance))
+	defaultService := service
+	var routes []urlRoute
+	if old != nil {
+		routes = parseUrlRoutes(old)
+		if len(c.Hosts) != 0 || len(c.Paths) != 0 {
+			defaultService = old.DefaultService
+		}
+	}
+
+	routes = withUrlRoutes(routes, service, c.urlRoutes(service))
+	return buildUrlMap(c.FrontendName(instance), c.Description(), defaultService, routes)
+}
+
+// urlRoutes returns a route to the service for every host and path of the
+// config, the hosts default to any host and the paths to every path.
+func (c *NetworkConfig) urlRoutes(service string) []urlRoute {
+	if len(c.Hosts) == 0 && len(c.Paths) == 0 {
+		return nil
+	}
+
+	hosts := c.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"*"}
+	}
+
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{"/*"}
+	}
+
+	var routes []urlRoute
+	for _, h := range hosts {
+		for _, p := range paths {
+			routes = append(routes, urlRoute{Host: h, Path: p, Service: service})
+		}
+	}
+
+	return routes
+}
+
+// withUrlRoutes replaces the routes of the service by the given ones, which
+// take over the routes of other services for the same host and path.
+func withUrlRoutes(routes []urlRoute, service string, own []urlRoute) []urlRoute {
+	claimed := make(map[string]bool, len(own))
+	for _, r := range own {
+		claimed[r.key()] = true
+	}
+
+	var merged []urlRoute
+	for _, r := range routes {
+		if ResourceName(r.Service) == ResourceName(service) || claimed[r.key()] {
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return append(merged, own...)
+}
+
+// withoutUrlRoutes returns the URL map without the routes of the service, nil
+// if no other backend service remains. When the service is the default one,
+// the service of another route takes its place.
+func withoutUrlRoutes(m *compute.UrlMap, service string) *compute.UrlMap {
+	routes := withUrlRoutes(parseUrlRoutes(m), service, nil)
+	defaultService := m.DefaultService
+	if ResourceName(defaultService) == ResourceName(service) {
+		if len(routes) == 0 {
+			return nil
+		}
+
+		defaultService = routes[0].Service
+	}
+
+	return buildUrlMap(m.Name, m.Description, defaultService, routes)
+}
+
+// restoreUrlRoutes returns the URL map with the routes of the service, and
+// the default one if it was, as in the previous map.
+func restoreUrlRoutes(current, previous *compute.UrlMap, service string) *compute.UrlMap {
+	var own []urlRoute
+	for _, r := range parseUrlRoutes(previous) {
+		if ResourceName(r.Service) == ResourceName(service) {
+			own = append(own, r)
+		}
+	}
+
+	defaultService := current.DefaultService
+	if ResourceName(defaultService) == ResourceName(service) || ResourceName(previous.DefaultService) == ResourceName(service) {
+		defaultService = previous.DefaultService
+	}
+
+	routes := withUrlRoutes(parseUrlRoutes(current), service, own)
+	return buildUrlMap(current.Name, current.Description, defaultService, routes)
+}
+
+// parseUrlRoutes returns the routes of a URL map built by buildUrlMap.
+func parseUrlRoutes(m *compute.UrlMap) []urlRoute {
+	matchers := make(map[string]*compute.PathMatcher, len(m.PathMatchers))
+	for _, pm := range m.PathMatchers {
+		matchers[pm.Name] = pm
+	}
+
+	var routes []urlRoute
+	for _, h := range m.HostRules {
+		pm, ok := matchers[h.PathMatcher]
+		if !ok {
+			continue
+		}
+
+		for _, host := range h.Hosts {
+			for _, rule := range pm.PathRules {
+				for _, path := range rule.Paths {
+					routes = append(routes, urlRoute{Host: host, Path: path, Service: rule.Service})
+				}
+			}
+		}
+	}
+
+	return routes
+}
+
+// buildUrlMap returns a URL map with a host rule and a path matcher for every
+// host, sorted so the same routes always build the same map. The paths not
+// routed for a host go to the default service.
+func buildUrlMap(name, description, defaultService string, routes []urlRoute) *compute.UrlMap {
+	m := &compute.UrlMap{
+		Name:           name,
+		Description:    description,
+		DefaultService: defaultService,
+	}
+
+	byHost := make(map[string][]urlRoute, 0)
+	var hosts []string
+	for _, r := range routes {
+		if _, ok := byHost[r.Host]; !ok {
+			hosts = append(hosts, r.Host)
+		}
+
+		byHost[r.Host] = append(byHost[r.Host], r)
+	}
+
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		pm := &compute.PathMatcher{Name: pathMatcherName(host), DefaultService: defaultService}
+		paths := make(map[string][]string, 0)
+		var services []string
+		for _, r := range byHost[host] {
+			if _, ok := paths[r.Service]; !ok {
+				services = append(services, r.Service)
+			}
+
+			paths[r.Service] = append(paths[r.Service], r.Path)
+		}
+
+		sort.Strings(services)
+		for _, s := range services {
+			sort.Strings(paths[s])
+			pm.PathRules = append(pm.PathRules, &compute.PathRule{Paths: paths[s], Service: s})
+		}
+
+		m.HostRules = append(m.HostRules, &compute.HostRule{Hosts: []string{host}, PathMatcher: pm.Name})
+		m.PathMatchers = append(m.PathMatchers, pm)
+	}
+
+	return m
+}
+
+func pathMatcherName(host string) string {
+	hash := md5.Sum([]byte(host))
+	return "host-" + hex.EncodeToString(hash[:])[:12]
+}
+
+// isUrlMapOutdated compares the default service and the routes.
+func isUrlMapOutdated(old, new *compute.UrlMap) bool {
+	return ResourceName(old.DefaultService) != ResourceName(new.DefaultService) ||
+		!equalSet(urlRouteKeys(parseUrlRoutes(old)), urlRouteKeys(parseUrlRoutes(new)))
+}
+
+func urlRouteKeys(routes []urlRoute) []string {
+	var keys []string
+	for _, r := range routes {
+		keys = append(keys, r.key()+" "+ResourceName(r.Service))
+	}
+
+	return keys
+}
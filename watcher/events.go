@@ -0,0 +1,68 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ReconnectDelay is the delay before subscribing again to the events, once
+// the stream is closed, doubled on every failed attempt up to MaxReconnectDelay.
+var (
+	ReconnectDelay    = 5 * time.Second
+	MaxReconnectDelay = 2 * time.Minute
+)
+
+// eventCursor tracks the time of the last event handled, the events replayed
+// after a reconnect, with the same time, are filtered out.
+type eventCursor struct {
+	last int64
+	seen map[string]bool
+}
+
+func newEventCursor() *eventCursor {
+	return &eventCursor{seen: make(map[string]bool, 0)}
+}
+
+// Accept returns false for the events already handled, otherwise the event is
+// recorded as the last one.
+func (c *eventCursor) Accept(e *docker.APIEvents) bool {
+	t := eventTime(e)
+	if t < c.last {
+		return false
+	}
+
+	if t > c.last {
+		c.last = t
+		c.seen = make(map[string]bool, 0)
+	}
+
+	key := e.ID + ":" + e.Status
+	if c.seen[key] {
+		return false
+	}
+
+	c.seen[key] = true
+	return true
+}
+
+// Since returns the time of the last event, in the format of the since filter
+// of the events API, empty if no event was handled.
+func (c *eventCursor) Since() string {
+	if c.last == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%09d", c.last/int64(time.Second), c.last%int64(time.Second))
+}
+
+// eventTime returns the time of the event in nanoseconds, the daemons prior
+// to API 1.22 only report seconds.
+func eventTime(e *docker.APIEvents) int64 {
+	if e.TimeNano != 0 {
+		return e.TimeNano
+	}
+
+	return e.Time * int64(time.Second)
+}
@@ -0,0 +1,31 @@
+package watcher
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type EventsSuite struct{}
+
+var _ = Suite(&EventsSuite{})
+
+func (s *EventsSuite) TestEventCursor(c *C) {
+	cursor := newEventCursor()
+	c.Assert(cursor.Since(), Equals, "")
+
+	start := &docker.APIEvents{ID: "foo", Status: "start", TimeNano: 1465552800000000001}
+	die := &docker.APIEvents{ID: "foo", Status: "die", TimeNano: 1465552800000000001}
+	c.Assert(cursor.Accept(start), Equals, true)
+	c.Assert(cursor.Accept(die), Equals, true)
+	c.Assert(cursor.Since(), Equals, "1465552800.000000001")
+
+	c.Assert(cursor.Accept(start), Equals, false)
+	c.Assert(cursor.Accept(die), Equals, false)
+
+	old := &docker.APIEvents{ID: "bar", Status: "start", Time: 1465552799}
+	c.Assert(cursor.Accept(old), Equals, false)
+
+	next := &docker.APIEvents{ID: "foo", Status: "start", Time: 1465552801}
+	c.Assert(cursor.Accept(next), Equals, true)
+	c.Assert(cursor.Since(), Equals, "1465552801.000000000")
+}
@@ -0,0 +1,63 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// A container restarted FlapThreshold times within FlapWindow is flapping,
+// and its load balancer is created once it runs for FlapStableTime.
+var (
+	FlapWindow     = 5 * time.Minute
+	FlapThreshold  = 3
+	FlapStableTime = time.Minute
+)
+
+// flapDetector tracks the recent restarts of the containers.
+type flapDetector struct {
+	restarts map[string][]time.Time
+	sync.Mutex
+}
+
+func newFlapDetector() *flapDetector {
+	return &flapDetector{restarts: make(map[string][]time.Time, 0)}
+}
+
+// Record records a restart of the container and returns the number of
+// restarts within FlapWindow.
+func (d *flapDetector) Record(id string, now time.Time) int {
+	d.Lock()
+	defer d.Unlock()
+
+	var recent []time.Time
+	for _, t := range d.restarts[id] {
+		if now.Sub(t) < FlapWindow {
+			recent = append(recent, t)
+		}
+	}
+
+	d.restarts[id] = append(recent, now)
+	return len(d.restarts[id])
+}
+
+// Count returns the number of restarts of the container within FlapWindow.
+func (d *flapDetector) Count(id string, now time.Time) int {
+	d.Lock()
+	defer d.Unlock()
+
+	var n int
+	for _, t := range d.restarts[id] {
+		if now.Sub(t) < FlapWindow {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (d *flapDetector) Forget(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.restarts, id)
+}
@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type FlappingSuite struct{}
+
+var _ = Suite(&FlappingSuite{})
+
+func (s *FlappingSuite) TestRecord(c *C) {
+	d := newFlapDetector()
+	now := time.Now()
+
+	c.Assert(d.Record("foo", now), Equals, 1)
+	c.Assert(d.Record("foo", now.Add(time.Minute)), Equals, 2)
+	c.Assert(d.Record("bar", now.Add(time.Minute)), Equals, 1)
+	c.Assert(d.Record("foo", now.Add(FlapWindow+30*time.Second)), Equals, 2)
+
+	c.Assert(d.Count("foo", now.Add(FlapWindow+30*time.Second)), Equals, 2)
+	c.Assert(d.Count("foo", now.Add(3*FlapWindow)), Equals, 0)
+
+	d.Forget("foo")
+	c.Assert(d.Count("foo", now), Equals, 0)
+	c.Assert(d.Record("foo", now), Equals, 1)
+}
+
+func (s *FlappingSuite) TestHasRestartPolicy(c *C) {
+	container := &docker.Container{}
+	c.Assert(hasRestartPolicy(container), Equals, false)
+
+	container.HostConfig = &docker.HostConfig{RestartPolicy: docker.NeverRestart()}
+	c.Assert(hasRestartPolicy(container), Equals, false)
+
+	container.HostConfig.RestartPolicy = docker.AlwaysRestart()
+	c.Assert(hasRestartPolicy(container), Equals, true)
+}
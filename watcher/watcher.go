@@ -3,7 +3,10 @@ package watcher
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/inconshreveable/log15.v2"
@@ -17,16 +20,63 @@ var (
 	LabelNetworkType            = LabelNetworkPrefix + "lb.type"
 	LabelNetworkGroup           = LabelNetworkPrefix + "lb.group"
 	LabelNetworkAddress         = LabelNetworkPrefix + "lb.address"
+	LabelNetworkAddressRelease  = LabelNetworkPrefix + "lb.address.release"
 	LabelNetworkSourceRanges    = LabelNetworkPrefix + "lb.source.ranges"
 	LabelNetworkSourceTags      = LabelNetworkPrefix + "lb.source.tags"
 	LabelNetworkSessionAffinity = LabelNetworkPrefix + "lb.session.affinity"
+	LabelNetworkScheme          = LabelNetworkPrefix + "lb.scheme"
+	LabelNetworkSubnetwork      = LabelNetworkPrefix + "lb.subnetwork"
+	LabelNetworkNetwork         = LabelNetworkPrefix + "lb.network"
+	LabelSecurityPolicy         = LabelNetworkPrefix + "lb.security.policy"
+	LabelSecurityAllow          = LabelNetworkPrefix + "lb.security.allow"
+	LabelNetworkIPVersion       = LabelNetworkPrefix + "lb.ip.version"
+	LabelNetworkHosts           = LabelNetworkPrefix + "lb.hosts"
+	LabelNetworkPaths           = LabelNetworkPrefix + "lb.paths"
+	LabelNetworkCertificate     = LabelNetworkPrefix + "lb.certificate"
+	LabelNetworkWaitHealthy     = LabelNetworkPrefix + "lb.wait.healthy"
+	LabelNetworkDrainSeconds    = LabelNetworkPrefix + "lb.drain.seconds"
+	LabelNetworkPorts           = LabelNetworkPrefix + "lb.ports"
+	LabelHealthCheckPath        = LabelNetworkPrefix + "lb.healthcheck.path"
+	LabelHealthCheckPort        = LabelNetworkPrefix + "lb.healthcheck.port"
+	LabelHealthCheckInterval    = LabelNetworkPrefix + "lb.healthcheck.interval"
+	LabelHealthCheckThreshold   = LabelNetworkPrefix + "lb.healthcheck.threshold"
+	LabelFirewallPriority       = LabelNetworkPrefix + "lb.firewall.priority"
+	LabelFirewallLogging        = LabelNetworkPrefix + "lb.firewall.logging"
+	LabelFirewallDeny           = LabelNetworkPrefix + "lb.firewall.deny"
+	LabelDNSName                = LabelNetworkPrefix + "dns.name"
+	LabelDNSZone                = LabelNetworkPrefix + "dns.zone"
+	LabelDNSTTL                 = LabelNetworkPrefix + "dns.ttl"
+)
+
+// DrainSignals are the signals, of the kill events, stopping the container.
+var DrainSignals = map[string]bool{"2": true, "3": true, "9": true, "15": true}
+
+// DefaultStopTimeout is the stop timeout, in seconds, of the containers
+// without one, as the docker daemon does.
+var DefaultStopTimeout = 10
+
+// Health statuses of the docker healthcheck, and the events of its changes.
+const (
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	StatusHealthy   = "health_status: " + HealthHealthy
+	StatusUnhealthy = "health_status: " + HealthUnhealthy
 )
 
 var validLabels = []string{
-	LabelNetworkType, LabelNetworkGroup, LabelNetworkAddress,
+	LabelNetworkType, LabelNetworkGroup, LabelNetworkAddress, LabelNetworkAddressRelease,
 	LabelNetworkSourceRanges, LabelNetworkSourceTags, LabelNetworkSessionAffinity,
+	LabelNetworkScheme, LabelNetworkSubnetwork, LabelNetworkHosts, LabelNetworkPaths,
+	LabelNetworkCertificate, LabelNetworkWaitHealthy, LabelNetworkDrainSeconds,
+	LabelNetworkPorts, LabelNetworkNetwork, LabelSecurityPolicy, LabelSecurityAllow,
+	LabelNetworkIPVersion,
+	LabelHealthCheckPath, LabelHealthCheckPort, LabelHealthCheckInterval,
+	LabelHealthCheckThreshold, LabelFirewallPriority, LabelFirewallLogging,
+	LabelFirewallDeny, LabelDNSName, LabelDNSZone, LabelDNSTTL,
 }
 
+var ListenerBufferSize = 100
+
 type Watcher struct {
 	WatchedStatus       map[string]bool
 	WatchedLabelsPrefix string
@@ -36,6 +86,12 @@ type Watcher struct {
 	p        *providers.Network
 	w        *Worker
 	listener chan *docker.APIEvents
+	flaps    *flapDetector
+
+	// configs caches the configs of the attached containers, since the
+	// removed containers can't be inspected anymore
+	configs map[string][]*providers.NetworkConfig
+	mu      sync.Mutex
 }
 
 func NewWatcher(d *docker.Client, c *http.Client, project, zone, instance string) (*Watcher, error) {
@@ -45,31 +101,173 @@ func NewWatcher(d *docker.Client, c *http.Client, project, zone, instance string
 	}
 
 	return &Watcher{
-		WatchedStatus:       map[string]bool{"die": true, "start": true},
+		WatchedStatus: map[string]bool{
+			"die": true, "start": true, "kill": true, "destroy": true, "pause": true,
+			"unpause": true, "oom": true, StatusHealthy: true, StatusUnhealthy: true,
+		},
 		WatchedLabelsPrefix: LabelNetworkPrefix,
 		DefaultDelay:        time.Second * 1,
 		c:                   d,
 		p:                   p,
 		w:                   NewWorker(),
+		flaps:               newFlapDetector(),
+		configs:             make(map[string][]*providers.NetworkConfig, 0),
 	}, nil
 }
 
+// Watch handles the events of the containers, when the event stream is closed,
+// as when the daemon restarts, the watcher subscribes again since the last
+// event handled, so the events missed in between are replayed.
 func (m *Watcher) Watch() error {
-	m.listener = make(chan *docker.APIEvents, 0)
+	cursor := newEventCursor()
+	if err := m.listen(cursor); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := m.Reconcile(); err != nil {
+			log15.Error("error reconciling networks", "error", err)
+		}
+	}()
+
+	for {
+		for e := range m.listener {
+			if !cursor.Accept(e) {
+				continue
+			}
+
+			if err := m.handleEvent(e); err != nil {
+				log15.Error("error handling event", "container", e.ID[:12], "error", err)
+			}
+		}
 
-	if err := m.c.AddEventListener(m.listener); err != nil {
+		log15.Warn("event stream closed, reconnecting", "since", cursor.Since())
+		m.reconnect(cursor)
+		log15.Info("event stream reconnected", "since", cursor.Since())
+	}
+}
+
+// reconnect subscribes again to the events until it succeeds, the delay
+// between attempts is doubled up to MaxReconnectDelay, as the daemon may take
+// a while to come back.
+func (m *Watcher) reconnect(cursor *eventCursor) {
+	delay := ReconnectDelay
+	for {
+		time.Sleep(delay)
+		err := m.listen(cursor)
+		if err == nil {
+			return
+		}
+
+		if delay *= 2; delay > MaxReconnectDelay {
+			delay = MaxReconnectDelay
+		}
+
+		log15.Error("error reconnecting to the event stream", "error", err, "retry", delay)
+	}
+}
+
+func (m *Watcher) listen(cursor *eventCursor) error {
+	m.listener = make(chan *docker.APIEvents, ListenerBufferSize)
+	return m.c.AddEventListenerWithOptions(docker.EventsOptions{
+		Since: cursor.Since(),
+	}, m.listener)
+}
+
+// Reconcile creates, or checks, the network of the running containers and
+// deletes the networks of the instance whose containers are gone, since the
+// events happened before the watcher started are never received.
+func (m *Watcher) Reconcile() error {
+	log15.Debug("reconciling networks")
+	containers, err := m.watchedContainers()
+	if err != nil {
 		return err
 	}
 
-	for e := range m.listener {
-		if err := m.handleEvent(e); err != nil {
-			log15.Error("error handling event", "container", e.ID[:12], "error", err)
+	var active []*providers.NetworkConfig
+	for c, labels := range containers {
+		active = append(active, m.createNetworkConfigs(c, labels)...)
+		if c.State.Health.Status == HealthUnhealthy {
+			if err := m.disable(c, labels, m.DefaultDelay); err != nil {
+				return err
+			}
+
+			continue
 		}
+
+		if !isReady(c, labels) {
+			continue
+		}
+
+		if err := m.attach(c, labels); err != nil {
+			return err
+		}
+	}
+
+	orphans, err := m.p.Orphans(active)
+	if err != nil {
+		return err
+	}
+
+	// the orphans of a container are deleted by a single job
+	orphaned := make(map[string][]*providers.NetworkConfig, 0)
+	for _, config := range orphans {
+		orphaned[config.Container] = append(orphaned[config.Container], config)
+	}
+
+	for container, configs := range orphaned {
+		log15.Info("orphaned network found", "container", container)
+		m.delete(JobID(container), configs, m.DefaultDelay)
 	}
 
 	return nil
 }
 
+// ActiveConfigs returns the network configs of the running containers.
+func (m *Watcher) ActiveConfigs() ([]*providers.NetworkConfig, error) {
+	containers, err := m.watchedContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var active []*providers.NetworkConfig
+	for c, labels := range containers {
+		active = append(active, m.createNetworkConfigs(c, labels)...)
+	}
+
+	return active, nil
+}
+
+// watchedContainers returns the running containers with valid watched labels.
+func (m *Watcher) watchedContainers() (map[*docker.Container]map[string]string, error) {
+	containers, err := m.c.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	watched := make(map[*docker.Container]map[string]string, 0)
+	for _, apiContainer := range containers {
+		c, err := m.c.InspectContainer(apiContainer.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := m.watchedLabels(c)
+		if len(labels) == 0 {
+			continue
+		}
+
+		if err := m.validateLabels(labels); err != nil {
+			log15.Error("invalid labels", "container", c.ID[:12], "error", err)
+			continue
+		}
+
+		watched[c] = labels
+	}
+
+	return watched, nil
+}
+
 func (m *Watcher) handleEvent(e *docker.APIEvents) error {
 	if !m.WatchedStatus[e.Status] {
 		return nil
@@ -77,7 +275,24 @@ func (m *Watcher) handleEvent(e *docker.APIEvents) error {
 
 	c, err := m.c.InspectContainer(e.ID)
 	if err != nil {
-		return err
+		// the container was removed, the network is deleted from the cache
+		if e.Status != "die" && e.Status != "destroy" {
+			return err
+		}
+
+		configs := m.cachedConfigs(e.ID)
+		if len(configs) == 0 {
+			if e.Status == "destroy" {
+				return nil
+			}
+
+			return err
+		}
+
+		log15.Debug("removed container detected, deleting network", "container", e.ID[:12])
+		m.forget(e.ID)
+		m.delete(JobID(e.ID), configs, m.DefaultDelay)
+		return nil
 	}
 
 	labels := m.watchedLabels(c)
@@ -92,10 +307,33 @@ func (m *Watcher) handleEvent(e *docker.APIEvents) error {
 	}
 
 	switch e.Status {
-	case "die":
+	case "kill":
+		// the kill event precedes the die one by the stop timeout, the stop
+		// event isn't watched since it comes after die
+		if !DrainSignals[e.Actor.Attributes["signal"]] {
+			return nil
+		}
+
+		return m.drain(c, labels)
+	case "die", "destroy":
 		return m.detach(c, labels)
-	case "start":
+	case "oom":
+		log15.Warn("container out of memory", "container", c.ID[:12])
+	case "pause":
+		return m.disable(c, labels, m.DefaultDelay)
+	case "unpause":
 		return m.attach(c, labels)
+	case "start":
+		if !isReady(c, labels) {
+			log15.Info("waiting for container to be healthy", "container", c.ID[:12])
+			return nil
+		}
+
+		return m.attachStable(c, labels)
+	case StatusHealthy:
+		return m.attachStable(c, labels)
+	case StatusUnhealthy:
+		return m.disable(c, labels, m.DefaultDelay)
 	}
 
 	return nil
@@ -116,62 +354,380 @@ func (m *Watcher) watchedLabels(c *docker.Container) map[string]string {
 
 func (m *Watcher) attach(c *docker.Container, l map[string]string) error {
 	jobID := JobID(c.ID)
+	configs, err := m.attachedConfigs(c, l)
+	if err != nil {
+		return err
+	}
 
 	m.w.Delete(jobID)
 	m.w.Add(jobID, func() error {
-		start := time.Now()
-		config := m.createNetworkConfig(c, l)
-		log15.Debug("start event detected, creating network",
-			"container", c.ID[:12], "ports", config.Ports,
-		)
+		for _, config := range configs {
+			m.createNetwork(config)
+		}
 
-		if err := m.p.Create(config); err != nil {
-			log15.Error("error creating network",
-				"container", c.ID[:12], "ports", config.Ports, "error", err,
-			)
+		return nil
+	}, m.DefaultDelay)
+
+	return nil
+}
+
+// attachStable attaches the container, unless it is flapping, then the load
+// balancer is only created once the container has been running for
+// FlapStableTime. A restart in the meantime replaces the job.
+func (m *Watcher) attachStable(c *docker.Container, l map[string]string) error {
+	n := m.flaps.Count(c.ID, time.Now())
+	if n < FlapThreshold {
+		return m.attach(c, l)
+	}
+
+	log15.Warn("container flapping, attach held off",
+		"container", c.ID[:12], "restarts", n, "stable", FlapStableTime,
+	)
+
+	jobID := JobID(c.ID)
+	m.w.Delete(jobID)
+	m.w.Add(jobID, func() error {
+		current, err := m.c.InspectContainer(c.ID)
+		if err != nil {
+			return err
+		}
+
+		if !current.State.Running || !current.State.StartedAt.Equal(c.State.StartedAt) {
 			return nil
 		}
 
-		log15.Info(
-			"network started",
-			"container", c.ID[:12], "ports", config.Ports, "elapsed", time.Since(start),
+		configs, err := m.attachedConfigs(current, l)
+		if err != nil {
+			log15.Error("error attaching stable container", "container", c.ID[:12], "error", err)
+			return err
+		}
+
+		log15.Info("container stable, attaching", "container", c.ID[:12])
+		for _, config := range configs {
+			m.createNetwork(config)
+		}
+
+		return nil
+	}, FlapStableTime)
+
+	return nil
+}
+
+// attachedConfigs returns the configs of the networks of the container, and
+// caches them.
+func (m *Watcher) attachedConfigs(c *docker.Container, l map[string]string) ([]*providers.NetworkConfig, error) {
+	configs := m.createNetworkConfigs(c, l)
+	if err := validateDrainSeconds(c, configs); err != nil {
+		return nil, err
+	}
+
+	m.remember(c.ID, configs)
+	return configs, nil
+}
+
+func (m *Watcher) createNetwork(config *providers.NetworkConfig) {
+	start := time.Now()
+	log15.Debug("start event detected, creating network",
+		"container", config.Container, "ports", config.Ports,
+	)
+
+	if err := m.p.Create(config); err != nil {
+		log15.Error("error creating network",
+			"container", config.Container, "ports", config.Ports, "error", err,
 		)
+		return
+	}
+
+	log15.Info(
+		"network started",
+		"container", config.Container, "ports", config.Ports, "elapsed", time.Since(start),
+	)
+}
+
+// disable removes the instance from the load balancer of an unhealthy or
+// stopping container, the instance is added back by attach.
+func (m *Watcher) disable(c *docker.Container, l map[string]string, delay time.Duration) error {
+	jobID := JobID(c.ID)
+
+	m.w.Delete(jobID)
+	m.w.Add(jobID, func() error {
+		log15.Debug("disabling network", "container", c.ID[:12])
+		for _, config := range m.createNetworkConfigs(c, l) {
+			if err := m.p.Disable(config); err != nil {
+				log15.Error("error disabling network", "container", c.ID[:12], "error", err)
+				continue
+			}
+
+			log15.Info("network disabled", "container", c.ID[:12], "ports", config.Ports)
+		}
+
 		return nil
-	}, m.DefaultDelay)
+	}, delay)
 
 	return nil
 }
 
-func (m *Watcher) detach(c *docker.Container, l map[string]string) error {
+// drain removes the instance from the load balancer of a container being
+// stopped. The die event replaces the job checking, after the stop timeout,
+// whether the container survived the signal, then the instance is added back.
+func (m *Watcher) drain(c *docker.Container, l map[string]string) error {
 	jobID := JobID(c.ID)
 
 	m.w.Delete(jobID)
-	m.w.Add(JobID(c.ID), func() error {
-		start := time.Now()
-		config := m.createNetworkConfig(c, l)
-		log15.Debug("stop event detected, deleting network",
-			"container", c.ID[:12], "ports", config.Ports,
-		)
+	m.w.Add(jobID, func() error {
+		log15.Debug("draining network", "container", c.ID[:12])
+		for _, config := range m.createNetworkConfigs(c, l) {
+			if err := m.p.Disable(config); err != nil {
+				log15.Error("error disabling network", "container", c.ID[:12], "error", err)
+				continue
+			}
+
+			log15.Info("network disabled", "container", c.ID[:12], "ports", config.Ports)
+		}
+
+		m.w.Add(jobID, func() error {
+			return m.undrain(c, l)
+		}, time.Duration(stopTimeout(c))*time.Second+m.DefaultDelay)
+
+		return nil
+	}, 0)
+
+	return nil
+}
+
+// undrain adds back the instance of a container still running after the stop
+// timeout, a stopped one is detached since its die event may have been
+// handled while draining.
+func (m *Watcher) undrain(c *docker.Container, l map[string]string) error {
+	current, err := m.c.InspectContainer(c.ID)
+	if err != nil || !current.State.Running || !current.State.StartedAt.Equal(c.State.StartedAt) {
+		return m.detach(c, l)
+	}
 
-		if err := m.p.Delete(config); err != nil {
-			log15.Error("error deleting network",
-				"container", c.ID[:12], "ports", config.Ports, "error", err,
+	log15.Info("container survived the stop signal, attaching", "container", c.ID[:12])
+	return m.attach(current, l)
+}
+
+// detach deletes the network of a stopped container, unless the container is
+// restarted by its restart policy. The container is inspected again once the
+// delay expires, since the restart policy may have started it already.
+func (m *Watcher) detach(c *docker.Container, l map[string]string) error {
+	log15.Debug("stop event detected, deleting network", "container", c.ID[:12])
+	jobID := JobID(c.ID)
+	configs := m.createNetworkConfigs(c, l)
+
+	if hasRestartPolicy(c) {
+		if n := m.flaps.Record(c.ID, time.Now()); n >= FlapThreshold {
+			log15.Warn("container restart flapping detected",
+				"container", c.ID[:12], "restarts", n, "window", FlapWindow,
 			)
+		}
+	}
 
+	m.w.Delete(jobID)
+	m.w.Add(jobID, func() error {
+		current, err := m.c.InspectContainer(c.ID)
+		if err == nil && (current.State.Running || current.State.Restarting) {
+			m.holdOff(current, configs)
 			return nil
 		}
 
-		log15.Info(
-			"network deleted",
-			"container", c.ID[:12], "ports", config.Ports, "elapsed", time.Since(start),
-		)
+		m.forget(c.ID)
+		for _, config := range configs {
+			m.deleteNetwork(config)
+		}
+
 		return nil
 	}, m.DefaultDelay)
 
 	return nil
 }
 
+// holdOff keeps the network of a container restarted by its restart policy,
+// the instance is removed from the load balancer until the container starts.
+func (m *Watcher) holdOff(c *docker.Container, configs []*providers.NetworkConfig) {
+	if !c.State.Restarting {
+		log15.Info("container restarted, teardown held off", "container", c.ID[:12])
+		return
+	}
+
+	log15.Info("container restarting, teardown held off", "container", c.ID[:12])
+	for _, config := range configs {
+		if err := m.p.Disable(config); err != nil {
+			log15.Error("error disabling network", "container", c.ID[:12], "error", err)
+		}
+	}
+}
+
+func hasRestartPolicy(c *docker.Container) bool {
+	if c.HostConfig == nil {
+		return false
+	}
+
+	policy := c.HostConfig.RestartPolicy.Name
+	return policy != "" && policy != "no"
+}
+
+func (m *Watcher) remember(id string, configs []*providers.NetworkConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.configs[id] = configs
+}
+
+func (m *Watcher) forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.configs, id)
+	m.flaps.Forget(id)
+}
+
+func (m *Watcher) cachedConfigs(id string) []*providers.NetworkConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.configs[id]
+}
+
+// validateDrainSeconds checks that the connections are drained before the
+// container is killed, the instance is removed from the load balancers when
+// the container is being stopped, and killed after the stop timeout.
+func validateDrainSeconds(c *docker.Container, configs []*providers.NetworkConfig) error {
+	timeout := stopTimeout(c)
+	for _, config := range configs {
+		if config.DrainSeconds > int64(timeout) {
+			return fmt.Errorf(
+				"invalid label %q, %d seconds exceed the stop timeout of the container, %d seconds",
+				LabelNetworkDrainSeconds, config.DrainSeconds, timeout,
+			)
+		}
+	}
+
+	return nil
+}
+
+// stopTimeout returns the seconds between the stop signal and the kill of the
+// container.
+func stopTimeout(c *docker.Container) int {
+	if c.Config != nil && c.Config.StopTimeout != 0 {
+		return c.Config.StopTimeout
+	}
+
+	return DefaultStopTimeout
+}
+
+func (m *Watcher) delete(jobID JobID, configs []*providers.NetworkConfig, delay time.Duration) {
+	m.w.Delete(jobID)
+	m.w.Add(jobID, func() error {
+		for _, config := range configs {
+			m.deleteNetwork(config)
+		}
+
+		return nil
+	}, delay)
+}
+
+func (m *Watcher) deleteNetwork(config *providers.NetworkConfig) {
+	start := time.Now()
+	log15.Debug("deleting network",
+		"container", config.Container, "ports", config.Ports,
+	)
+
+	if err := m.p.Delete(config); err != nil {
+		log15.Error("error deleting network",
+			"container", config.Container, "ports", config.Ports, "error", err,
+		)
+
+		return
+	}
+
+	log15.Info(
+		"network deleted",
+		"container", config.Container, "ports", config.Ports, "elapsed", time.Since(start),
+	)
+}
+
+// isReady returns false when the container should be healthy before being
+// attached, and it isn't yet.
+func isReady(c *docker.Container, l map[string]string) bool {
+	for _, set := range labelSets(l) {
+		wait, _ := strconv.ParseBool(set[LabelNetworkWaitHealthy])
+		if wait && c.State.Health.Status != HealthHealthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// labelSets splits the labels into the indexed sets, as `gce.lb.0.type`, keyed
+// by index and with the index removed from the label names. The labels without
+// index are the defaults of every set, when no indexed label is given a single
+// set with an empty index is returned.
+func labelSets(l map[string]string) map[string]map[string]string {
+	defaults := make(map[string]string, 0)
+	indexed := make(map[string]map[string]string, 0)
+	for label, value := range l {
+		name, index := splitLabelIndex(label)
+		if index == "" {
+			defaults[name] = value
+			continue
+		}
+
+		if indexed[index] == nil {
+			indexed[index] = make(map[string]string, 0)
+		}
+
+		indexed[index][name] = value
+	}
+
+	if len(indexed) == 0 {
+		return map[string]map[string]string{"": defaults}
+	}
+
+	for _, set := range indexed {
+		for label, value := range defaults {
+			if _, ok := set[label]; !ok {
+				set[label] = value
+			}
+		}
+	}
+
+	return indexed
+}
+
+// splitLabelIndex returns the label without index and the index, if any, as
+// `gce.lb.type` and `0` for `gce.lb.0.type`.
+func splitLabelIndex(label string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(label, LabelNetworkPrefix), ".", 3)
+	if len(parts) != 3 {
+		return label, ""
+	}
+
+	if _, err := strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return label, ""
+	}
+
+	return LabelNetworkPrefix + parts[0] + "." + parts[2], parts[1]
+}
+
+// validateLabels validates every label set of the container.
 func (m *Watcher) validateLabels(l map[string]string) error {
+	for index, set := range labelSets(l) {
+		if err := m.validateLabelSet(set); err != nil {
+			if index == "" {
+				return err
+			}
+
+			return fmt.Errorf("invalid label set %s: %s", index, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Watcher) validateLabelSet(l map[string]string) error {
 	if l[LabelNetworkType] == "" {
 		return fmt.Errorf("invalid label %q, should be provided`", LabelNetworkType)
 	}
@@ -184,9 +740,208 @@ func (m *Watcher) validateLabels(l map[string]string) error {
 		return fmt.Errorf("invalid label %q, cannot be empty when %q is static", LabelNetworkAddress, LabelNetworkType)
 	}
 
+	for _, label := range []string{
+		LabelHealthCheckPort, LabelHealthCheckInterval, LabelHealthCheckThreshold,
+		LabelDNSTTL, LabelNetworkDrainSeconds,
+	} {
+		if _, ok := l[label]; !ok {
+			continue
+		}
+
+		if _, err := strconv.ParseInt(l[label], 10, 64); err != nil {
+			return fmt.Errorf("invalid label %q, must be a number", label)
+		}
+	}
+
+	if v, ok := l[LabelFirewallPriority]; ok {
+		priority, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || priority < 0 || priority > 65535 {
+			return fmt.Errorf("invalid label %q, must be a number between 0 and 65535", LabelFirewallPriority)
+		}
+
+		if priority == 0 && l[LabelFirewallDeny] != "" {
+			return fmt.Errorf("invalid label %q, must be above 0 along with %q", LabelFirewallPriority, LabelFirewallDeny)
+		}
+	}
+
+	switch l[LabelNetworkScheme] {
+	case "", providers.SchemeExternal, providers.SchemeInternal, providers.SchemeHTTPS:
+	default:
+		return fmt.Errorf("invalid label %q value must be `%s`, `%s` or `%s`",
+			LabelNetworkScheme, providers.SchemeExternal, providers.SchemeInternal, providers.SchemeHTTPS,
+		)
+	}
+
+	for _, label := range []string{
+		LabelNetworkHosts, LabelNetworkPaths, LabelNetworkCertificate, LabelSecurityPolicy, LabelSecurityAllow,
+	} {
+		if l[label] != "" && l[LabelNetworkScheme] != providers.SchemeHTTPS {
+			return fmt.Errorf("invalid label %q, requires %q to be `%s`", label, LabelNetworkScheme, providers.SchemeHTTPS)
+		}
+	}
+
+	ipv6 := false
+	switch strings.ToUpper(l[LabelNetworkIPVersion]) {
+	case "", providers.IPVersion4:
+	case providers.IPVersion6, providers.IPVersionDual:
+		if l[LabelNetworkScheme] == providers.SchemeInternal {
+			return fmt.Errorf("invalid label %q, IPv6 isn't supported by %q `%s`", LabelNetworkIPVersion, LabelNetworkScheme, providers.SchemeInternal)
+		}
+
+		ipv6 = true
+	default:
+		return fmt.Errorf("invalid label %q value must be `%s`, `%s` or `%s`",
+			LabelNetworkIPVersion, providers.IPVersion4, providers.IPVersion6, providers.IPVersionDual,
+		)
+	}
+
+	// the target pools don't drain the connections of a removed instance
+	backend := l[LabelNetworkScheme] == providers.SchemeInternal || l[LabelNetworkScheme] == providers.SchemeHTTPS || ipv6
+	if l[LabelNetworkDrainSeconds] != "" && !backend {
+		return fmt.Errorf("invalid label %q, requires %q to be `%s`, `%s` or IPv6", LabelNetworkDrainSeconds,
+			LabelNetworkScheme, providers.SchemeInternal, providers.SchemeHTTPS,
+		)
+	}
+
+	if l[LabelSecurityPolicy] != "" && l[LabelSecurityAllow] != "" {
+		return fmt.Errorf("invalid label %q, cannot be used along with %q", LabelSecurityAllow, LabelSecurityPolicy)
+	}
+
+	// the IPv6 external forwarding rules take their address from a subnetwork
+	external := l[LabelNetworkScheme] == "" || l[LabelNetworkScheme] == providers.SchemeExternal
+	if l[LabelNetworkSubnetwork] != "" && l[LabelNetworkScheme] != providers.SchemeInternal && !(external && ipv6) {
+		return fmt.Errorf("invalid label %q, requires %q to be `%s` or IPv6", LabelNetworkSubnetwork, LabelNetworkScheme, providers.SchemeInternal)
+	}
+
+	if l[LabelDNSName] == "" && (l[LabelDNSZone] != "" || l[LabelDNSTTL] != "") {
+		return fmt.Errorf("invalid label %q, should be provided along with %q or %q", LabelDNSName, LabelDNSZone, LabelDNSTTL)
+	}
+
+	for _, label := range []string{LabelFirewallLogging, LabelNetworkAddressRelease, LabelNetworkWaitHealthy} {
+		if v, ok := l[label]; ok {
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("invalid label %q, must be `true` or `false`", label)
+			}
+		}
+	}
+
+	if v, ok := l[LabelNetworkPorts]; ok {
+		if err := validatePorts(v); err != nil {
+			return fmt.Errorf("invalid label %q, %s", LabelNetworkPorts, err)
+		}
+	}
+
 	return nil
 }
 
+// validatePorts validates a list of ports, or ranges of ports, with an
+// optional protocol, as `80,53/udp,30000-30100/udp`.
+func validatePorts(value string) error {
+	for _, spec := range strings.Split(value, ",") {
+		if _, _, _, err := parsePorts(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsePorts parses a port, or a range of ports, with an optional protocol,
+// as `80`, `53/udp` or `30000-30100/udp`. The protocol is empty if missing.
+func parsePorts(spec string) (from, to uint64, proto string, err error) {
+	port := docker.Port(spec)
+	if strings.Contains(spec, "/") {
+		proto = port.Proto()
+		if proto != "tcp" && proto != "udp" {
+			return 0, 0, "", fmt.Errorf("invalid protocol of port %q, must be `tcp` or `udp`", spec)
+		}
+	}
+
+	bounds := strings.SplitN(port.Port(), "-", 2)
+	from, err = strconv.ParseUint(bounds[0], 10, 16)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid port %q", spec)
+	}
+
+	to = from
+	if len(bounds) == 2 {
+		to, err = strconv.ParseUint(bounds[1], 10, 16)
+		if err != nil || to < from {
+			return 0, 0, "", fmt.Errorf("invalid port range %q", spec)
+		}
+	}
+
+	return from, to, proto, nil
+}
+
+// matchPorts returns true if the port is listed, a listed port without
+// protocol matches any protocol. Every port matches an empty list.
+func matchPorts(value string, port docker.Port) bool {
+	if value == "" {
+		return true
+	}
+
+	number, err := strconv.ParseUint(port.Port(), 10, 16)
+	if err != nil {
+		return false
+	}
+
+	for _, spec := range strings.Split(value, ",") {
+		from, to, proto, err := parsePorts(spec)
+		if err != nil {
+			continue
+		}
+
+		if number >= from && number <= to && (proto == "" || proto == port.Proto()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostPorts returns every port listed, used by the containers at the host
+// network, which don't publish any port. The ports without protocol are tcp.
+func hostPorts(value string) []docker.Port {
+	var ports []docker.Port
+	for _, spec := range strings.Split(value, ",") {
+		from, to, proto, err := parsePorts(spec)
+		if err != nil {
+			continue
+		}
+
+		if proto == "" {
+			proto = "tcp"
+		}
+
+		for port := from; port <= to; port++ {
+			ports = append(ports, docker.Port(fmt.Sprintf("%d/%s", port, proto)))
+		}
+	}
+
+	return ports
+}
+
+// createNetworkConfigs returns a config for every label set of the container.
+func (m *Watcher) createNetworkConfigs(c *docker.Container, l map[string]string) []*providers.NetworkConfig {
+	sets := labelSets(l)
+	var indexes []string
+	for index := range sets {
+		indexes = append(indexes, index)
+	}
+
+	sort.Strings(indexes)
+
+	var configs []*providers.NetworkConfig
+	for _, index := range indexes {
+		n := m.createNetworkConfig(c, sets[index])
+		n.Index = index
+		configs = append(configs, n)
+	}
+
+	return configs
+}
+
 func (m *Watcher) createNetworkConfig(c *docker.Container, l map[string]string) *providers.NetworkConfig {
 	n := m.createNetworkConfigFromLabels(l)
 	n.Container = c.ID[:12]
@@ -195,16 +950,27 @@ func (m *Watcher) createNetworkConfig(c *docker.Container, l map[string]string)
 		return n
 	}
 
+	if c.HostConfig.NetworkMode == "host" && l[LabelNetworkPorts] != "" {
+		n.Ports = providers.CompactPorts(hostPorts(l[LabelNetworkPorts]))
+		return n
+	}
+
 	for internal, externals := range c.HostConfig.PortBindings {
 		for _, external := range externals {
 			if external.HostIP != "0.0.0.0" && external.HostIP != "" {
 				continue
 			}
 
-			n.Ports = append(n.Ports, docker.Port(external.HostPort+"/"+internal.Proto()))
+			port := docker.Port(external.HostPort + "/" + internal.Proto())
+			if !matchPorts(l[LabelNetworkPorts], port) {
+				continue
+			}
+
+			n.Ports = append(n.Ports, port)
 		}
 	}
 
+	n.Ports = providers.CompactPorts(n.Ports)
 	return n
 }
 
@@ -217,14 +983,80 @@ func (m *Watcher) createNetworkConfigFromLabels(l map[string]string) *providers.
 			n.GroupName = value
 		case LabelNetworkAddress:
 			n.Address = value
+		case LabelNetworkAddressRelease:
+			n.ReleaseAddress, _ = strconv.ParseBool(value)
 		case LabelNetworkSourceRanges:
 			n.Source.Ranges = strings.Split(value, ",")
 		case LabelNetworkSourceTags:
-			n.Source.Ranges = strings.Split(value, ",")
+			n.Source.Tags = strings.Split(value, ",")
 		case LabelNetworkSessionAffinity:
 			n.SessionAffinity = providers.SessionAffinity(value)
+		case LabelNetworkScheme:
+			n.Scheme = value
+		case LabelNetworkSubnetwork:
+			n.Subnetwork = value
+		case LabelNetworkNetwork:
+			n.Network = value
+		case LabelNetworkHosts:
+			n.Hosts = strings.Split(value, ",")
+		case LabelNetworkPaths:
+			n.Paths = strings.Split(value, ",")
+		case LabelNetworkCertificate:
+			n.Certificate = value
+		case LabelNetworkIPVersion:
+			n.IPVersion = strings.ToUpper(value)
+		case LabelSecurityPolicy:
+			n.SecurityPolicy = value
+		case LabelSecurityAllow:
+			n.SecurityAllow = strings.Split(value, ",")
+		case LabelNetworkDrainSeconds:
+			n.DrainSeconds, _ = strconv.ParseInt(value, 10, 64)
+		case LabelHealthCheckPath:
+			n.HealthCheck = healthCheck(n)
+			n.HealthCheck.Path = value
+		case LabelHealthCheckPort:
+			n.HealthCheck = healthCheck(n)
+			n.HealthCheck.Port, _ = strconv.ParseInt(value, 10, 64)
+		case LabelHealthCheckInterval:
+			n.HealthCheck = healthCheck(n)
+			n.HealthCheck.Interval, _ = strconv.ParseInt(value, 10, 64)
+		case LabelHealthCheckThreshold:
+			n.HealthCheck = healthCheck(n)
+			n.HealthCheck.Threshold, _ = strconv.ParseInt(value, 10, 64)
+		case LabelFirewallPriority:
+			priority, _ := strconv.ParseInt(value, 10, 64)
+			n.Priority = &priority
+		case LabelFirewallLogging:
+			n.Logging, _ = strconv.ParseBool(value)
+		case LabelFirewallDeny:
+			n.Deny = strings.Split(value, ",")
+		case LabelDNSName:
+			n.DNS = dnsRecord(n)
+			n.DNS.Name = value
+		case LabelDNSZone:
+			n.DNS = dnsRecord(n)
+			n.DNS.Zone = value
+		case LabelDNSTTL:
+			n.DNS = dnsRecord(n)
+			n.DNS.TTL, _ = strconv.ParseInt(value, 10, 64)
 		}
 	}
 
 	return n
 }
+
+func healthCheck(n *providers.NetworkConfig) *providers.HealthCheck {
+	if n.HealthCheck == nil {
+		return &providers.HealthCheck{}
+	}
+
+	return n.HealthCheck
+}
+
+func dnsRecord(n *providers.NetworkConfig) *providers.DNS {
+	if n.DNS == nil {
+		return &providers.DNS{}
+	}
+
+	return n.DNS
+}
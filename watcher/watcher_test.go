@@ -4,7 +4,9 @@ import (
 	"encoding/base64"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/bloomapi/gce-docker/providers"
 	"github.com/fsouza/go-dockerclient"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -77,3 +79,340 @@ func (s *WatcherSuite) TestStart(c *C) {
 	err = w.Watch()
 	c.Assert(err, IsNil)
 }
+
+type LabelsSuite struct{}
+
+var _ = Suite(&LabelsSuite{})
+
+func (s *LabelsSuite) TestValidateLabels(c *C) {
+	w := &Watcher{}
+	c.Assert(w.validateLabels(map[string]string{
+		"gce.lb.type": "ephemeral",
+	}), IsNil)
+
+	c.Assert(w.validateLabels(map[string]string{
+		"gce.lb.type":                 "ephemeral",
+		"gce.lb.healthcheck.interval": "foo",
+	}), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsHealthCheck(c *C) {
+	w := &Watcher{}
+	n := w.createNetworkConfigFromLabels(map[string]string{
+		"gce.lb.type": "ephemeral",
+	})
+	c.Assert(n.HealthCheck, IsNil)
+
+	n = w.createNetworkConfigFromLabels(map[string]string{
+		"gce.lb.type":                  "ephemeral",
+		"gce.lb.healthcheck.path":      "/health",
+		"gce.lb.healthcheck.port":      "8080",
+		"gce.lb.healthcheck.interval":  "5",
+		"gce.lb.healthcheck.threshold": "3",
+	})
+	c.Assert(n.HealthCheck, DeepEquals, &providers.HealthCheck{
+		Path: "/health", Port: 8080, Interval: 5, Threshold: 3,
+	})
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsFirewall(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":              "ephemeral",
+		"gce.lb.source.tags":       "foo,bar",
+		"gce.lb.firewall.priority": "500",
+		"gce.lb.firewall.logging":  "true",
+		"gce.lb.firewall.deny":     "192.0.2.0/24,198.51.100.0/24",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.Source.Tags, DeepEquals, []string{"foo", "bar"})
+	c.Assert(n.Source.Ranges, HasLen, 0)
+	c.Assert(*n.Priority, Equals, int64(500))
+	c.Assert(n.Logging, Equals, true)
+	c.Assert(n.Deny, DeepEquals, []string{"192.0.2.0/24", "198.51.100.0/24"})
+
+	l["gce.lb.firewall.priority"] = "0"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	l["gce.lb.firewall.priority"] = "500"
+	l["gce.lb.firewall.logging"] = "foo"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsDNS(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":  "ephemeral",
+		"gce.dns.name": "www.example.com",
+		"gce.dns.ttl":  "60",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.DNS, DeepEquals, &providers.DNS{Name: "www.example.com", TTL: 60})
+
+	delete(l, "gce.dns.name")
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsAddressRelease(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":            "static",
+		"gce.lb.address":         "web",
+		"gce.lb.address.release": "true",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.Address, Equals, "web")
+	c.Assert(n.ReleaseAddress, Equals, true)
+
+	l["gce.lb.address.release"] = "foo"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsScheme(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":       "ephemeral",
+		"gce.lb.scheme":     "internal",
+		"gce.lb.subnetwork": "backend",
+		"gce.lb.network":    "vpc",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.Scheme, Equals, "internal")
+	c.Assert(n.Subnetwork, Equals, "backend")
+	c.Assert(n.Network, Equals, "vpc")
+
+	l["gce.lb.scheme"] = "external"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	l["gce.lb.scheme"] = "foo"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsHTTPS(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":   "ephemeral",
+		"gce.lb.scheme": "https",
+		"gce.lb.hosts":  "example.com,www.example.com",
+		"gce.lb.paths":  "/api/*",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.Scheme, Equals, "https")
+	c.Assert(n.Hosts, DeepEquals, []string{"example.com", "www.example.com"})
+	c.Assert(n.Paths, DeepEquals, []string{"/api/*"})
+
+	l["gce.lb.scheme"] = "internal"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsIPVersion(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":       "ephemeral",
+		"gce.lb.scheme":     "https",
+		"gce.lb.hosts":      "example.com",
+		"gce.lb.ip.version": "dual",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+	c.Assert(w.createNetworkConfigFromLabels(l).IPVersion, Equals, "DUAL")
+
+	l["gce.lb.ip.version"] = "IPV5"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	l["gce.lb.ip.version"] = "IPV6"
+	l["gce.lb.scheme"] = "internal"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	delete(l, "gce.lb.hosts")
+	l["gce.lb.scheme"] = "external"
+	l["gce.lb.subnetwork"] = "frontend"
+	c.Assert(w.validateLabels(l), IsNil)
+
+	l["gce.lb.ip.version"] = "IPV4"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigFromLabelsSecurity(c *C) {
+	w := &Watcher{}
+	l := map[string]string{
+		"gce.lb.type":           "ephemeral",
+		"gce.lb.scheme":         "https",
+		"gce.lb.hosts":          "example.com",
+		"gce.lb.security.allow": "192.0.2.0/24,198.51.100.0/24",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.SecurityAllow, DeepEquals, []string{"192.0.2.0/24", "198.51.100.0/24"})
+
+	l["gce.lb.security.policy"] = "waf"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	delete(l, "gce.lb.security.allow")
+	c.Assert(w.validateLabels(l), IsNil)
+	c.Assert(w.createNetworkConfigFromLabels(l).SecurityPolicy, Equals, "waf")
+
+	l["gce.lb.scheme"] = "external"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestIsReady(c *C) {
+	container := &docker.Container{}
+	l := map[string]string{"gce.lb.type": "ephemeral"}
+	c.Assert(isReady(container, l), Equals, true)
+
+	l["gce.lb.wait.healthy"] = "true"
+	c.Assert(isReady(container, l), Equals, false)
+
+	container.State.Health.Status = "starting"
+	c.Assert(isReady(container, l), Equals, false)
+
+	container.State.Health.Status = "healthy"
+	c.Assert(isReady(container, l), Equals, true)
+}
+
+func (s *LabelsSuite) TestDrainSeconds(c *C) {
+	w := &Watcher{DefaultDelay: time.Second}
+	l := map[string]string{
+		"gce.lb.type":          "ephemeral",
+		"gce.lb.drain.seconds": "30",
+	}
+	c.Assert(w.validateLabels(l), NotNil)
+
+	l["gce.lb.ip.version"] = "DUAL"
+	c.Assert(w.validateLabels(l), IsNil)
+
+	delete(l, "gce.lb.ip.version")
+	l["gce.lb.scheme"] = "internal"
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfigFromLabels(l)
+	c.Assert(n.DrainSeconds, Equals, int64(30))
+
+	container := &docker.Container{Config: &docker.Config{}}
+	configs := []*providers.NetworkConfig{n}
+	c.Assert(validateDrainSeconds(container, configs), NotNil)
+
+	container.Config.StopTimeout = 30
+	c.Assert(validateDrainSeconds(container, configs), IsNil)
+
+	n.DrainSeconds = 0
+	container.Config.StopTimeout = 0
+	c.Assert(validateDrainSeconds(container, configs), IsNil)
+
+	l["gce.lb.drain.seconds"] = "foo"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestLabelSets(c *C) {
+	l := map[string]string{"gce.lb.type": "ephemeral"}
+	c.Assert(labelSets(l), DeepEquals, map[string]map[string]string{"": l})
+
+	l = map[string]string{
+		"gce.lb.type":                "ephemeral",
+		"gce.lb.healthcheck.path":    "/health",
+		"gce.lb.0.ports":             "80",
+		"gce.lb.1.scheme":            "internal",
+		"gce.lb.1.ports":             "9000/tcp",
+		"gce.lb.1.healthcheck.path":  "/admin",
+		"gce.dns.1.name":             "admin.example.com",
+		"gce.lb.healthcheck.1.probe": "foo",
+	}
+
+	c.Assert(labelSets(l), DeepEquals, map[string]map[string]string{
+		"0": {
+			"gce.lb.type":                "ephemeral",
+			"gce.lb.healthcheck.path":    "/health",
+			"gce.lb.healthcheck.1.probe": "foo",
+			"gce.lb.ports":               "80",
+		},
+		"1": {
+			"gce.lb.type":                "ephemeral",
+			"gce.lb.healthcheck.path":    "/admin",
+			"gce.lb.healthcheck.1.probe": "foo",
+			"gce.lb.scheme":              "internal",
+			"gce.lb.ports":               "9000/tcp",
+			"gce.dns.name":               "admin.example.com",
+		},
+	})
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigs(c *C) {
+	w := &Watcher{}
+	container := &docker.Container{
+		ID: "abcdefghijklm",
+		HostConfig: &docker.HostConfig{
+			PortBindings: map[docker.Port][]docker.PortBinding{
+				"80/tcp":   {{HostPort: "80"}},
+				"9000/tcp": {{HostPort: "9000"}},
+				"53/udp":   {{HostPort: "53"}},
+			},
+		},
+	}
+
+	l := map[string]string{
+		"gce.lb.type":     "ephemeral",
+		"gce.lb.0.ports":  "80,53/udp",
+		"gce.lb.1.scheme": "internal",
+		"gce.lb.1.ports":  "9000",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	configs := w.createNetworkConfigs(container, l)
+	c.Assert(configs, HasLen, 2)
+	c.Assert(configs[0].Index, Equals, "0")
+	c.Assert(configs[0].Ports, HasLen, 2)
+	c.Assert(configs[1].Index, Equals, "1")
+	c.Assert(configs[1].Scheme, Equals, "internal")
+	c.Assert(configs[1].Ports, DeepEquals, []docker.Port{"9000/tcp"})
+	c.Assert(configs[0].Name("foo"), Not(Equals), configs[1].Name("foo"))
+
+	l["gce.lb.1.ports"] = "9000/sctp"
+	c.Assert(w.validateLabels(l), NotNil)
+
+	l["gce.lb.1.ports"] = "foo"
+	c.Assert(w.validateLabels(l), NotNil)
+}
+
+func (s *LabelsSuite) TestCreateNetworkConfigPortRanges(c *C) {
+	w := &Watcher{}
+	container := &docker.Container{
+		ID: "abcdefghijklm",
+		HostConfig: &docker.HostConfig{
+			PortBindings: map[docker.Port][]docker.PortBinding{
+				"80/tcp":    {{HostPort: "80"}},
+				"30000/udp": {{HostPort: "30000"}},
+				"30001/udp": {{HostPort: "30001"}},
+				"30001/tcp": {{HostPort: "30001"}},
+			},
+		},
+	}
+
+	l := map[string]string{
+		"gce.lb.type":  "ephemeral",
+		"gce.lb.ports": "30000-30100/udp",
+	}
+	c.Assert(w.validateLabels(l), IsNil)
+
+	n := w.createNetworkConfig(container, l)
+	c.Assert(n.Ports, DeepEquals, []docker.Port{"30000-30001/udp"})
+	c.Assert(matchPorts(l["gce.lb.ports"], "30001/tcp"), Equals, false)
+
+	container.HostConfig = &docker.HostConfig{NetworkMode: "host"}
+	l["gce.lb.ports"] = "80,5000-5002/udp"
+	n = w.createNetworkConfig(container, l)
+	c.Assert(n.Ports, DeepEquals, []docker.Port{"80/tcp", "5000-5002/udp"})
+
+	l["gce.lb.ports"] = "5002-5000"
+	c.Assert(w.validateLabels(l), NotNil)
+}
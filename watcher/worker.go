@@ -8,14 +8,22 @@ import (
 type JobID string
 type Job func() error
 
+// queuedJob is a job added to the worker, the token tells apart the jobs
+// added with the same id.
+type queuedJob struct {
+	job   Job
+	token uint64
+}
+
 type Worker struct {
-	jobs map[JobID]Job
+	jobs  map[JobID]queuedJob
+	token uint64
 	sync.Mutex
 }
 
 func NewWorker() *Worker {
 	return &Worker{
-		jobs: make(map[JobID]Job, 0),
+		jobs: make(map[JobID]queuedJob, 0),
 	}
 }
 
@@ -23,17 +31,27 @@ func (w *Worker) Add(id JobID, j Job, delay time.Duration) {
 	w.Lock()
 	defer w.Unlock()
 
-	w.jobs[id] = j
-	go w.do(id, delay)
+	w.token++
+	w.jobs[id] = queuedJob{job: j, token: w.token}
+	go w.do(id, w.token, delay)
 }
 
-func (w *Worker) do(id JobID, delay time.Duration) {
+// do runs the job once the delay expires, unless it was deleted or replaced
+// by a job added later with the same id, which is then left queued.
+func (w *Worker) do(id JobID, token uint64, delay time.Duration) {
 	<-time.After(delay)
-	defer w.Delete(id)
 
-	if j, ok := w.jobs[id]; ok {
-		j()
+	w.Lock()
+	q, ok := w.jobs[id]
+	if !ok || q.token != token {
+		w.Unlock()
+		return
 	}
+
+	delete(w.jobs, id)
+	w.Unlock()
+
+	q.job()
 }
 
 func (w *Worker) Delete(id JobID) bool {
@@ -46,3 +46,32 @@ func (s *WorkerSuite) TestAddAndDelete(c *C) {
 
 	c.Assert(since, Equals, time.Duration(0))
 }
+
+func (s *WorkerSuite) TestAddWhileRunning(c *C) {
+	id := JobID("foo")
+	delay := 10 * time.Millisecond
+	running := make(chan bool)
+	added := make(chan bool)
+	done := make(chan bool, 1)
+
+	w := NewWorker()
+	w.Add(id, func() error {
+		running <- true
+		<-added
+		return nil
+	}, 0)
+
+	<-running
+	w.Delete(id)
+	w.Add(id, func() error {
+		done <- true
+		return nil
+	}, delay)
+	close(added)
+
+	select {
+	case <-done:
+	case <-time.After(delay * 10):
+		c.Fatal("job added while the previous one was running never ran")
+	}
+}